@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Authentication types supported by RequestAuth
+const (
+	AuthTypeInherit = "inherit"
+	AuthTypeNone    = "none"
+	AuthTypeBasic   = "basic"
+	AuthTypeBearer  = "bearer"
+	AuthTypeAPIKey  = "apikey"
+	AuthTypeDigest  = "digest"
+)
+
+// RequestAuth describes how a request is authenticated. Requests with an empty or
+// "inherit" type use the auth configured on their collection.
+type RequestAuth struct {
+	Type     string        `json:"type"`
+	Basic    *BasicAuth    `json:"basic,omitempty"`
+	Bearer   *BearerAuth   `json:"bearer,omitempty"`
+	APIKey   *APIKeyAuth   `json:"apiKey,omitempty"`
+	Digest   *DigestAuth   `json:"digest,omitempty"`
+	OAuth2   *OAuth2Auth   `json:"oauth2,omitempty"`
+	AWSSigV4 *AWSSigV4Auth `json:"awsSigV4,omitempty"`
+	HMAC     *HMACAuth     `json:"hmac,omitempty"`
+	JWT      *JWTAuth      `json:"jwt,omitempty"`
+}
+
+// BasicAuth holds HTTP Basic credentials
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// BearerAuth holds a bearer token; Prefix defaults to "Bearer"
+type BearerAuth struct {
+	Token  string `json:"token"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// APIKeyAuth sends a key either as a header or as a query parameter
+type APIKeyAuth struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	In    string `json:"in"` // "header" or "query"
+}
+
+// DigestAuth holds HTTP Digest credentials
+type DigestAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// effectiveAuth returns the auth that applies to a request, falling back to the collection's auth.
+// Credentials sealed in a saved collection are returned decrypted.
+func (h *HTTPService) effectiveAuth(ctx context.Context, req HTTPRequest) (*RequestAuth, error) {
+	if req.Auth != nil && req.Auth.Type != "" && req.Auth.Type != AuthTypeInherit {
+		if h.collectionService == nil {
+			return req.Auth, nil
+		}
+		return h.collectionService.secrets.openAuth(req.Auth)
+	}
+
+	if req.CollectionID == "" || h.collectionService == nil {
+		return nil, nil
+	}
+
+	collection, err := h.collectionService.GetCollection(ctx, req.CollectionID)
+	if err != nil || collection.Auth == nil || collection.Auth.Type == AuthTypeInherit {
+		return nil, nil
+	}
+	return h.collectionService.secrets.openAuth(collection.Auth)
+}
+
+// expandAuth returns a copy of auth with variables substituted in every credential field
+func expandAuth(auth *RequestAuth, r *variableResolver) *RequestAuth {
+	if auth == nil {
+		return nil
+	}
+
+	expanded := &RequestAuth{Type: auth.Type}
+	if auth.Basic != nil {
+		expanded.Basic = &BasicAuth{
+			Username: r.expand(auth.Basic.Username),
+			Password: r.expand(auth.Basic.Password),
+		}
+	}
+	if auth.Bearer != nil {
+		expanded.Bearer = &BearerAuth{
+			Token:  r.expand(auth.Bearer.Token),
+			Prefix: auth.Bearer.Prefix,
+		}
+	}
+	if auth.APIKey != nil {
+		expanded.APIKey = &APIKeyAuth{
+			Key:   r.expand(auth.APIKey.Key),
+			Value: r.expand(auth.APIKey.Value),
+			In:    auth.APIKey.In,
+		}
+	}
+	if auth.Digest != nil {
+		expanded.Digest = &DigestAuth{
+			Username: r.expand(auth.Digest.Username),
+			Password: r.expand(auth.Digest.Password),
+		}
+	}
+	if auth.OAuth2 != nil {
+		expanded.OAuth2 = &OAuth2Auth{
+			GrantType:    auth.OAuth2.GrantType,
+			TokenURL:     r.expand(auth.OAuth2.TokenURL),
+			AuthURL:      r.expand(auth.OAuth2.AuthURL),
+			ClientID:     r.expand(auth.OAuth2.ClientID),
+			ClientSecret: r.expand(auth.OAuth2.ClientSecret),
+			Scope:        r.expand(auth.OAuth2.Scope),
+			Username:     r.expand(auth.OAuth2.Username),
+			Password:     r.expand(auth.OAuth2.Password),
+			RefreshToken: r.expand(auth.OAuth2.RefreshToken),
+			RedirectPort: auth.OAuth2.RedirectPort,
+			ClientAuth:   auth.OAuth2.ClientAuth,
+			HeaderPrefix: auth.OAuth2.HeaderPrefix,
+		}
+	}
+	if auth.AWSSigV4 != nil {
+		expanded.AWSSigV4 = expandAWSSigV4(auth.AWSSigV4, r)
+	}
+	if auth.HMAC != nil {
+		expanded.HMAC = expandHMAC(auth.HMAC, r)
+	}
+	if auth.JWT != nil {
+		expanded.JWT = expandJWT(auth.JWT, r)
+	}
+	return expanded
+}
+
+// applyAuth adds the credentials for auth to the outgoing request. tokenScope identifies
+// the collection and environment OAuth2 tokens are cached for.
+// Digest auth is handled by doWithAuth since it needs the server's challenge.
+func (h *HTTPService) applyAuth(httpReq *http.Request, auth *RequestAuth, tokenScope string, settings sendSettings) error {
+	if auth == nil {
+		return nil
+	}
+
+	switch auth.Type {
+	case "", AuthTypeInherit, AuthTypeNone, AuthTypeDigest:
+		return nil
+	case AuthTypeBasic:
+		if auth.Basic == nil {
+			return fmt.Errorf("basic auth is missing credentials")
+		}
+		httpReq.SetBasicAuth(auth.Basic.Username, auth.Basic.Password)
+	case AuthTypeBearer:
+		if auth.Bearer == nil || auth.Bearer.Token == "" {
+			return fmt.Errorf("bearer auth is missing a token")
+		}
+		prefix := auth.Bearer.Prefix
+		if prefix == "" {
+			prefix = "Bearer"
+		}
+		httpReq.Header.Set("Authorization", prefix+" "+auth.Bearer.Token)
+	case AuthTypeAPIKey:
+		if auth.APIKey == nil || auth.APIKey.Key == "" {
+			return fmt.Errorf("API key auth is missing a key name")
+		}
+		switch strings.ToLower(auth.APIKey.In) {
+		case "", "header":
+			httpReq.Header.Set(auth.APIKey.Key, auth.APIKey.Value)
+		case "query":
+			// Append rather than re-encode so the existing parameter order and encoding are kept
+			param := encodeQueryPart(auth.APIKey.Key) + "=" + encodeQueryPart(auth.APIKey.Value)
+			if httpReq.URL.RawQuery == "" {
+				httpReq.URL.RawQuery = param
+			} else {
+				httpReq.URL.RawQuery += "&" + param
+			}
+		default:
+			return fmt.Errorf("unsupported API key location %q", auth.APIKey.In)
+		}
+	case AuthTypeOAuth2:
+		if auth.OAuth2 == nil {
+			return fmt.Errorf("OAuth2 auth is missing its configuration")
+		}
+		client, err := h.oauth2Client(settings, auth.OAuth2.TokenURL)
+		if err != nil {
+			return err
+		}
+		token, err := h.oauth2.token(httpReq.Context(), client, tokenScope, auth.OAuth2, false)
+		if err != nil {
+			return fmt.Errorf("failed to obtain OAuth2 token: %w", err)
+		}
+		httpReq.Header.Set("Authorization", auth.OAuth2.authorizationHeader(token))
+	case AuthTypeAWSSigV4:
+		if auth.AWSSigV4 == nil {
+			return fmt.Errorf("AWS SigV4 auth is missing its configuration")
+		}
+		// Signing covers the final headers and body, so this must run last
+		if err := signAWSRequest(httpReq, auth.AWSSigV4, time.Now()); err != nil {
+			return fmt.Errorf("failed to sign request: %w", err)
+		}
+	case AuthTypeHMAC:
+		if auth.HMAC == nil {
+			return fmt.Errorf("HMAC auth is missing its configuration")
+		}
+		// The signature covers the final body, so this must run last
+		if err := signHMACRequest(httpReq, auth.HMAC, time.Now()); err != nil {
+			return fmt.Errorf("failed to sign request: %w", err)
+		}
+	case AuthTypeJWT:
+		if auth.JWT == nil {
+			return fmt.Errorf("JWT auth is missing its configuration")
+		}
+		if err := applyJWT(httpReq, auth.JWT, time.Now()); err != nil {
+			return fmt.Errorf("failed to mint JWT: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported auth type %q", auth.Type)
+	}
+
+	return nil
+}
+
+// doWithAuth sends the request, answering a Digest challenge or refreshing an OAuth2 token
+// if the server responds with 401. It returns the response together with the request that produced it.
+// redirects is the recorder used by client, so a retry reports only its own redirect chain.
+func (h *HTTPService) doWithAuth(client *http.Client, httpReq *http.Request, auth *RequestAuth, tokenScope string, settings sendSettings, redirects *redirectRecorder) (*http.Response, *http.Request, error) {
+	// The client adds the jar's cookies to httpReq itself, so keep the ones set by the request
+	cookies := append([]string(nil), httpReq.Header.Values("Cookie")...)
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if auth == nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, httpReq, nil
+	}
+
+	var authorization string
+	switch {
+	case auth.Type == AuthTypeDigest && auth.Digest != nil:
+		challenge, ok := parseDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+		if !ok {
+			return resp, httpReq, nil
+		}
+		authorization, err = challenge.authorize(httpReq, auth.Digest)
+		if err != nil {
+			resp.Body.Close()
+			return nil, nil, err
+		}
+	case auth.Type == AuthTypeOAuth2 && auth.OAuth2 != nil:
+		// The cached token may have been revoked; fetch a fresh one and try once more
+		tokenClient, err := h.oauth2Client(settings, auth.OAuth2.TokenURL)
+		if err != nil {
+			fmt.Printf("Warning: failed to refresh OAuth2 token after 401: %v\n", err)
+			return resp, httpReq, nil
+		}
+		token, err := h.oauth2.token(httpReq.Context(), tokenClient, tokenScope, auth.OAuth2, true)
+		if err != nil {
+			fmt.Printf("Warning: failed to refresh OAuth2 token after 401: %v\n", err)
+			return resp, httpReq, nil
+		}
+		authorization = auth.OAuth2.authorizationHeader(token)
+	default:
+		return resp, httpReq, nil
+	}
+
+	// Drain the rejected response so the connection can be reused
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	retry, err := cloneRequestWithBody(httpReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	retry.Header.Set("Authorization", authorization)
+	retry.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		retry.Header.Add("Cookie", cookie)
+	}
+
+	redirects.reset()
+	resp, err = client.Do(retry)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, retry, nil
+}
+
+// cloneRequestWithBody clones a request, re-opening its body so it can be sent again
+func cloneRequestWithBody(httpReq *http.Request) (*http.Request, error) {
+	clone := httpReq.Clone(httpReq.Context())
+	if httpReq.Body != nil && httpReq.Body != http.NoBody {
+		if httpReq.GetBody == nil {
+			return nil, fmt.Errorf("request body cannot be replayed")
+		}
+		body, err := httpReq.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay request body: %w", err)
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
+
+// digestChallenge holds the parameters of a WWW-Authenticate: Digest header
+type digestChallenge struct {
+	params map[string]string
+}
+
+// parseDigestChallenge finds and parses the first Digest challenge among the given header values
+func parseDigestChallenge(values []string) (*digestChallenge, bool) {
+	for _, value := range values {
+		scheme, rest, _ := strings.Cut(strings.TrimSpace(value), " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		return &digestChallenge{params: parseAuthParams(rest)}, true
+	}
+	return nil, false
+}
+
+// parseAuthParams parses comma-separated key=value pairs, where values may be quoted
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " ")
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			// Quoted string, honouring backslash escapes
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest); i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+					b.WriteByte(rest[i])
+					continue
+				}
+				if rest[i] == '"' {
+					break
+				}
+				b.WriteByte(rest[i])
+			}
+			value = b.String()
+			if i < len(rest) {
+				i++
+			}
+			s = rest[i:]
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+		params[key] = value
+	}
+	return params
+}
+
+// authorize computes the Authorization header answering the challenge for the given request
+func (c *digestChallenge) authorize(req *http.Request, creds *DigestAuth) (string, error) {
+	algorithm := c.params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+
+	var newHash func() hash.Hash
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	digest := func(s string) string {
+		h := newHash()
+		io.WriteString(h, s)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	cnonceBytes := make([]byte, 8)
+	if _, err := rand.Read(cnonceBytes); err != nil {
+		return "", fmt.Errorf("failed to generate cnonce: %w", err)
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	nonce := c.params["nonce"]
+	realm := c.params["realm"]
+	nc := "00000001"
+	uri := req.URL.RequestURI()
+
+	ha1 := digest(creds.Username + ":" + realm + ":" + creds.Password)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = digest(ha1 + ":" + nonce + ":" + cnonce)
+	}
+
+	// Prefer qop=auth; fall back to auth-int, which also covers the body
+	qop := ""
+	if offered := c.params["qop"]; offered != "" {
+		for _, q := range strings.Split(offered, ",") {
+			q = strings.TrimSpace(q)
+			if q == "auth" {
+				qop = q
+				break
+			}
+			if q == "auth-int" {
+				qop = q
+			}
+		}
+		if qop == "" {
+			return "", fmt.Errorf("unsupported digest qop %q", offered)
+		}
+	}
+
+	ha2 := digest(req.Method + ":" + uri)
+	if qop == "auth-int" {
+		body, err := readReplayableBody(req)
+		if err != nil {
+			return "", err
+		}
+		ha2 = digest(req.Method + ":" + uri + ":" + digest(string(body)))
+	}
+
+	var response string
+	if qop == "" {
+		response = digest(ha1 + ":" + nonce + ":" + ha2)
+	} else {
+		response = digest(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+
+	fields := []string{
+		fmt.Sprintf(`username="%s"`, creds.Username),
+		fmt.Sprintf(`realm="%s"`, realm),
+		fmt.Sprintf(`nonce="%s"`, nonce),
+		fmt.Sprintf(`uri="%s"`, uri),
+		fmt.Sprintf(`algorithm=%s`, algorithm),
+		fmt.Sprintf(`response="%s"`, response),
+	}
+	if opaque, ok := c.params["opaque"]; ok {
+		fields = append(fields, fmt.Sprintf(`opaque="%s"`, opaque))
+	}
+	if qop != "" {
+		fields = append(fields, "qop="+qop, "nc="+nc, fmt.Sprintf(`cnonce="%s"`, cnonce))
+	}
+
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+// readReplayableBody reads a copy of the request body without consuming the one that will be sent
+func readReplayableBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("request body cannot be replayed")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay request body: %w", err)
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRetryAfter401SendsCookiesOnceAndResetsRedirects(t *testing.T) {
+	h := newTestHTTPService(t)
+	tokens := newTokenServer(t, func(form url.Values) (string, string, int) {
+		if form.Get("grant_type") == OAuth2GrantRefreshToken {
+			return "fresh", "", http.StatusOK
+		}
+		return "revoked", "refresh-1", http.StatusOK
+	})
+
+	var cookieHeaders []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "1", Path: "/"})
+			return
+		case "/start":
+			http.Redirect(w, r, "/api", http.StatusFound)
+			return
+		}
+		cookieHeaders = append(cookieHeaders, strings.Join(r.Header.Values("Cookie"), "; "))
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(api.Close)
+
+	// Put a cookie in the jar before the authenticated request
+	if _, err := h.SendRequest(context.Background(), HTTPRequest{Method: http.MethodGet, URL: api.URL + "/login"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := h.SendRequest(context.Background(), HTTPRequest{
+		Method:  http.MethodGet,
+		URL:     api.URL + "/start",
+		Headers: Headers{{Key: "Cookie", Value: "pref=dark", Enabled: true}},
+		Auth: &RequestAuth{Type: AuthTypeOAuth2, OAuth2: &OAuth2Auth{
+			TokenURL:     tokens.URL,
+			ClientID:     "client",
+			ClientSecret: "s3cret",
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200 after refreshing the token", resp.StatusCode)
+	}
+	if len(resp.Redirects) != 1 {
+		t.Errorf("redirects = %d, want only the retry's 1", len(resp.Redirects))
+	}
+	if len(cookieHeaders) != 2 {
+		t.Fatalf("api saw %d requests, want 2", len(cookieHeaders))
+	}
+	if got := cookieHeaders[1]; got != "pref=dark; sid=1" {
+		t.Errorf("retry sent Cookie %q, want each cookie once", got)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AuthTypeAWSSigV4 signs requests with AWS Signature Version 4
+const AuthTypeAWSSigV4 = "awsv4"
+
+// awsSigningAlgorithm is the algorithm identifier used in SigV4 headers
+const awsSigningAlgorithm = "AWS4-HMAC-SHA256"
+
+// awsUnsignedHeaders are left out of the signature because proxies or the transport may change them
+var awsUnsignedHeaders = map[string]bool{
+	"authorization":   true,
+	"user-agent":      true,
+	"x-amzn-trace-id": true,
+	"expect":          true,
+	"connection":      true,
+	"content-length":  true,
+}
+
+// AWSSigV4Auth holds the credentials and scope for AWS Signature Version 4.
+// Empty credentials fall back to the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN and AWS_REGION variables.
+type AWSSigV4Auth struct {
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	SessionToken    string `json:"sessionToken,omitempty"`
+	Region          string `json:"region"`
+	Service         string `json:"service"`
+}
+
+// expandAWSSigV4 substitutes variables in the SigV4 configuration, applying the AWS_* variable fallbacks
+func expandAWSSigV4(cfg *AWSSigV4Auth, r *variableResolver) *AWSSigV4Auth {
+	withDefault := func(value, variable string) string {
+		if value == "" {
+			value = "{{" + variable + "}}"
+		}
+		return r.expand(value)
+	}
+
+	sessionToken := r.expand(cfg.SessionToken)
+	if sessionToken == "" {
+		sessionToken = r.values["AWS_SESSION_TOKEN"]
+	}
+
+	return &AWSSigV4Auth{
+		AccessKeyID:     withDefault(cfg.AccessKeyID, "AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: withDefault(cfg.SecretAccessKey, "AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    sessionToken,
+		Region:          withDefault(cfg.Region, "AWS_REGION"),
+		Service:         r.expand(cfg.Service),
+	}
+}
+
+// signAWSRequest adds SigV4 X-Amz-* and Authorization headers to the final request
+func signAWSRequest(httpReq *http.Request, cfg *AWSSigV4Auth, now time.Time) error {
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return fmt.Errorf("AWS SigV4 requires an access key and secret key")
+	}
+	if cfg.Region == "" || cfg.Service == "" {
+		return fmt.Errorf("AWS SigV4 requires a region and service")
+	}
+
+	body, err := readReplayableBody(httpReq)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256Hex(body)
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	httpReq.Header.Set("X-Amz-Date", amzDate)
+	if cfg.SessionToken != "" {
+		httpReq.Header.Set("X-Amz-Security-Token", cfg.SessionToken)
+	}
+	if cfg.Service == "s3" {
+		httpReq.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	canonicalHeaders, signedHeaders := awsCanonicalHeaders(httpReq)
+	canonicalRequest := strings.Join([]string{
+		httpReq.Method,
+		awsCanonicalURI(httpReq.URL, cfg.Service != "s3"),
+		awsCanonicalQuery(httpReq.URL),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	credentialScope := strings.Join([]string{date, cfg.Region, cfg.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		awsSigningAlgorithm,
+		amzDate,
+		credentialScope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+cfg.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, cfg.Region)
+	signingKey = hmacSHA256(signingKey, cfg.Service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	httpReq.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsSigningAlgorithm, cfg.AccessKeyID, credentialScope, signedHeaders, signature))
+
+	return nil
+}
+
+// awsCanonicalHeaders returns the canonical header block and the signed header list
+func awsCanonicalHeaders(httpReq *http.Request) (string, string) {
+	host := httpReq.Host
+	if host == "" {
+		host = httpReq.URL.Host
+	}
+
+	values := map[string]string{"host": host}
+	for key, vals := range httpReq.Header {
+		name := strings.ToLower(key)
+		if awsUnsignedHeaders[name] {
+			continue
+		}
+		trimmed := make([]string, len(vals))
+		for i, v := range vals {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		values[name] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(values[name])
+		b.WriteByte('\n')
+	}
+
+	return b.String(), strings.Join(names, ";")
+}
+
+// awsCanonicalURI encodes the path; every service except S3 expects each segment to be encoded twice
+func awsCanonicalURI(u *url.URL, doubleEncode bool) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if doubleEncode {
+			segments[i] = awsURIEncode(segment)
+			continue
+		}
+		if decoded, err := url.PathUnescape(segment); err == nil {
+			segment = decoded
+		}
+		segments[i] = awsURIEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// awsCanonicalQuery sorts and encodes the query string
+func awsCanonicalQuery(u *url.URL) string {
+	query := u.Query()
+	if len(query) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, awsURIEncode(key)+"="+awsURIEncode(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode percent-encodes everything except the RFC 3986 unreserved characters
+func awsURIEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// sha256Hex returns the lowercase hex SHA-256 of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns HMAC-SHA256(key, data)
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// awsTestCredentials are the credentials used by AWS's published SigV4 test suite
+var awsTestCredentials = &AWSSigV4Auth{
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	Region:          "us-east-1",
+	Service:         "service",
+}
+
+// awsTestTime is the signing time of the published test suite
+var awsTestTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+func TestSignAWSRequestVectors(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		body          string
+		contentType   string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			name:          "get-vanilla",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "post-x-www-form-urlencoded",
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			body:          "Param1=value1",
+			contentType:   "application/x-www-form-urlencoded",
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.body == "" {
+				req.Body, req.GetBody, req.ContentLength = nil, nil, 0
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			// The transport adds these; they must not be signed
+			req.Header.Set("User-Agent", "captain-api")
+
+			if err := signAWSRequest(req, awsTestCredentials, awsTestTime); err != nil {
+				t.Fatal(err)
+			}
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=" + tt.signedHeaders + ", Signature=" + tt.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization =\n%s\nwant\n%s", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q, want 20150830T123600Z", got)
+			}
+		})
+	}
+}
+
+func TestAWSCanonicalURI(t *testing.T) {
+	tests := []struct {
+		path         string
+		doubleEncode bool
+		want         string
+	}{
+		{"", true, "/"},
+		{"/", true, "/"},
+		{"/example space/", true, "/example%2520space/"},
+		{"/example space/", false, "/example%20space/"},
+		{"/a%2Fb/c", true, "/a%252Fb/c"},
+		{"/a%2Fb/c", false, "/a%2Fb/c"},
+		{"/documents and settings/~user", false, "/documents%20and%20settings/~user"},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse("https://example.amazonaws.com" + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := awsCanonicalURI(u, tt.doubleEncode); got != tt.want {
+			t.Errorf("awsCanonicalURI(%q, %v) = %q, want %q", tt.path, tt.doubleEncode, got, tt.want)
+		}
+	}
+}
+
+func TestAWSCanonicalQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", ""},
+		{"Param2=value2&Param1=value1", "Param1=value1&Param2=value2"},
+		{"Param1=value2&Param1=Value1", "Param1=Value1&Param1=value2"},
+		{"a=b+c&d=e%2Ff", "a=b%20c&d=e%2Ff"},
+		{"-._~0123456789=-._~", "-._~0123456789=-._~"},
+		{"key", "key="},
+	}
+
+	for _, tt := range tests {
+		u := &url.URL{RawQuery: tt.query}
+		if got := awsCanonicalQuery(u); got != tt.want {
+			t.Errorf("awsCanonicalQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ClientCertificate is a client certificate presented to hosts matching Host.
+// Host is an exact name such as api.example.com, a wildcard such as *.example.com,
+// or either of those with a :port; "*" matches every host.
+type ClientCertificate struct {
+	ID         string    `json:"id"`
+	Host       string    `json:"host"`
+	CertFile   string    `json:"certFile,omitempty"`   // PEM certificate, optionally followed by its chain
+	KeyFile    string    `json:"keyFile,omitempty"`    // PEM private key
+	PFXFile    string    `json:"pfxFile,omitempty"`    // PKCS#12 bundle, used instead of CertFile and KeyFile
+	Passphrase string    `json:"passphrase,omitempty"` // PKCS#12 password, encrypted at rest
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// CACertificate is a PEM bundle of extra certificate authorities trusted for hosts matching Host
+type CACertificate struct {
+	ID        string    `json:"id"`
+	Host      string    `json:"host"`
+	File      string    `json:"file"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CertificateSettings holds every registered client certificate and CA bundle
+type CertificateSettings struct {
+	ClientCertificates []ClientCertificate `json:"clientCertificates"`
+	CACertificates     []CACertificate     `json:"caCertificates"`
+}
+
+// certificateStore persists certificate settings and picks the TLS material for a host.
+// The settings are read once and kept until they are next saved.
+type certificateStore struct {
+	mu      sync.Mutex
+	path    string
+	secrets *secretKeeper
+	cached  *CertificateSettings
+}
+
+// newCertificateStore creates a store backed by ~/.captain-api/certificates.json
+func newCertificateStore(secrets *secretKeeper) *certificateStore {
+	homeDir, _ := os.UserHomeDir()
+	return &certificateStore{
+		path:    filepath.Join(homeDir, ".captain-api", "certificates.json"),
+		secrets: secrets,
+	}
+}
+
+// load reads the stored settings; a missing file means no certificates
+func (s *certificateStore) load() (*CertificateSettings, error) {
+	settings := &CertificateSettings{
+		ClientCertificates: []ClientCertificate{},
+		CACertificates:     []CACertificate{},
+	}
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificates: %w", err)
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		return nil, fmt.Errorf("failed to parse certificates: %w", err)
+	}
+	return settings, nil
+}
+
+// save writes the settings, keeping the file private since it references key material
+func (s *certificateStore) save(settings *CertificateSettings) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal certificates: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+// update loads the settings, applies fn and saves the result
+func (s *certificateStore) update(fn func(*CertificateSettings) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings, err := s.load()
+	if err != nil {
+		return err
+	}
+	if err := fn(settings); err != nil {
+		return err
+	}
+	if err := s.save(settings); err != nil {
+		return err
+	}
+	s.cached = settings
+	return nil
+}
+
+// current returns the cached settings, loading them on first use. The result must not be modified.
+func (s *certificateStore) current() (*CertificateSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cached == nil {
+		settings, err := s.load()
+		if err != nil {
+			return nil, err
+		}
+		s.cached = settings
+	}
+	return s.cached, nil
+}
+
+// tlsSelection is the client certificate and CA bundles chosen for a host
+type tlsSelection struct {
+	clientCert *ClientCertificate
+	cas        []CACertificate
+}
+
+// forHost picks the most specific client certificate and every CA bundle matching host and port
+func (s *certificateStore) forHost(host, port string) (*tlsSelection, error) {
+	settings, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+
+	selection := &tlsSelection{}
+	best := -1
+	for i, cert := range settings.ClientCertificates {
+		if score := matchHostPattern(cert.Host, host, port); score > best {
+			best = score
+			selection.clientCert = &settings.ClientCertificates[i]
+		}
+	}
+	for _, ca := range settings.CACertificates {
+		if matchHostPattern(ca.Host, host, port) >= 0 {
+			selection.cas = append(selection.cas, ca)
+		}
+	}
+	return selection, nil
+}
+
+// ids identifies the selection for transport caching. Each ID carries the modification time
+// and size of its files, so a certificate replaced on disk gets a new transport.
+func (sel *tlsSelection) ids() (string, string) {
+	clientCertID := ""
+	if sel.clientCert != nil {
+		clientCertID = sel.clientCert.ID + "@" + fileStamp(sel.clientCert.CertFile, sel.clientCert.KeyFile, sel.clientCert.PFXFile)
+	}
+	caIDs := make([]string, len(sel.cas))
+	for i, ca := range sel.cas {
+		caIDs[i] = ca.ID + "@" + fileStamp(ca.File)
+	}
+	return clientCertID, strings.Join(caIDs, ",")
+}
+
+// fileStamp summarises the modification time and size of each named file
+func fileStamp(paths ...string) string {
+	var stamps []string
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			stamps = append(stamps, "missing")
+			continue
+		}
+		stamps = append(stamps, fmt.Sprintf("%d.%d", info.ModTime().UnixNano(), info.Size()))
+	}
+	return strings.Join(stamps, "/")
+}
+
+// configure loads the selected certificates into a TLS config
+func (s *certificateStore) configure(config *tls.Config, sel *tlsSelection) error {
+	if sel.clientCert != nil {
+		passphrase, err := s.secrets.decrypt(sel.clientCert.Passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt passphrase for certificate %s: %w", sel.clientCert.ID, err)
+		}
+		cert, err := loadClientCertificate(*sel.clientCert, passphrase)
+		if err != nil {
+			return fmt.Errorf("client certificate %s: %w", sel.clientCert.ID, err)
+		}
+		config.Certificates = []tls.Certificate{*cert}
+	}
+
+	if len(sel.cas) == 0 {
+		return nil
+	}
+
+	// Extend the system roots so public hosts still verify
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	for _, ca := range sel.cas {
+		data, err := os.ReadFile(ca.File)
+		if err != nil {
+			return fmt.Errorf("CA certificate %s: %w", ca.ID, err)
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return fmt.Errorf("CA certificate %s: no PEM certificates found in %s", ca.ID, ca.File)
+		}
+	}
+	config.RootCAs = roots
+	return nil
+}
+
+// loadClientCertificate reads a PEM pair or a PKCS#12 bundle
+func loadClientCertificate(cert ClientCertificate, passphrase string) (*tls.Certificate, error) {
+	if cert.PFXFile == "" {
+		if cert.CertFile == "" || cert.KeyFile == "" {
+			return nil, fmt.Errorf("a certificate and key file, or a PKCS#12 file, is required")
+		}
+		pair, err := tls.LoadX509KeyPair(cert.CertFile, cert.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key pair: %w", err)
+		}
+		return &pair, nil
+	}
+
+	data, err := os.ReadFile(cert.PFXFile)
+	if err != nil {
+		return nil, err
+	}
+	key, leaf, chain, err := pkcs12.DecodeChain(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PKCS#12 file: %w", err)
+	}
+
+	pair := tls.Certificate{PrivateKey: key, Leaf: leaf, Certificate: [][]byte{leaf.Raw}}
+	for _, cert := range chain {
+		pair.Certificate = append(pair.Certificate, cert.Raw)
+	}
+	return &pair, nil
+}
+
+// matchHostPattern scores how specifically pattern matches host and port, or returns -1.
+// An empty pattern matches nothing.
+// Exact names beat wildcards, longer wildcards beat shorter ones, and a port makes a pattern more specific.
+func matchHostPattern(pattern, host, port string) int {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host = strings.ToLower(host)
+
+	switch pattern {
+	case "":
+		return -1
+	case "*":
+		return 0
+	}
+
+	score := 0
+	if patternHost, patternPort, err := net.SplitHostPort(pattern); err == nil {
+		if patternPort != port {
+			return -1
+		}
+		pattern = patternHost
+		score++
+	}
+
+	switch {
+	case pattern == host:
+		return score + 2000
+	case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]):
+		return score + 2*len(pattern)
+	}
+	return -1
+}
+
+// GetCertificates returns the registered client certificates and CA bundles with passphrases masked
+func (h *HTTPService) GetCertificates(ctx context.Context) (*CertificateSettings, error) {
+	h.certificates.mu.Lock()
+	defer h.certificates.mu.Unlock()
+
+	settings, err := h.certificates.load()
+	if err != nil {
+		return nil, err
+	}
+	for i := range settings.ClientCertificates {
+		if settings.ClientCertificates[i].Passphrase != "" {
+			settings.ClientCertificates[i].Passphrase = secretMask
+		}
+	}
+	return settings, nil
+}
+
+// SaveClientCertificate adds or replaces a client certificate after checking that it loads.
+// A masked passphrase keeps the stored one.
+func (h *HTTPService) SaveClientCertificate(ctx context.Context, cert ClientCertificate) (*ClientCertificate, error) {
+	if strings.TrimSpace(cert.Host) == "" {
+		return nil, fmt.Errorf("a host is required")
+	}
+	if cert.ID == "" {
+		cert.ID = fmt.Sprintf("cert_%d", time.Now().UnixNano())
+	}
+	if cert.CreatedAt.IsZero() {
+		cert.CreatedAt = time.Now()
+	}
+
+	err := h.certificates.update(func(settings *CertificateSettings) error {
+		index := -1
+		for i, existing := range settings.ClientCertificates {
+			if existing.ID == cert.ID {
+				index = i
+				if cert.Passphrase == secretMask {
+					cert.Passphrase = existing.Passphrase
+				}
+			}
+		}
+
+		passphrase, err := h.certificates.secrets.decrypt(cert.Passphrase)
+		if err != nil {
+			return err
+		}
+		if _, err := loadClientCertificate(cert, passphrase); err != nil {
+			return err
+		}
+		if cert.Passphrase, err = h.certificates.secrets.encrypt(passphrase); err != nil {
+			return fmt.Errorf("failed to encrypt passphrase: %w", err)
+		}
+
+		if index >= 0 {
+			settings.ClientCertificates[index] = cert
+		} else {
+			settings.ClientCertificates = append(settings.ClientCertificates, cert)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Connections made with the old material must not be reused
+	h.transports.clear()
+
+	cert.Passphrase = ""
+	return &cert, nil
+}
+
+// DeleteClientCertificate removes a client certificate
+func (h *HTTPService) DeleteClientCertificate(ctx context.Context, id string) error {
+	err := h.certificates.update(func(settings *CertificateSettings) error {
+		for i, cert := range settings.ClientCertificates {
+			if cert.ID == id {
+				settings.ClientCertificates = append(settings.ClientCertificates[:i], settings.ClientCertificates[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("client certificate %s not found", id)
+	})
+	if err != nil {
+		return err
+	}
+	h.transports.clear()
+	return nil
+}
+
+// SaveCACertificate adds or replaces a CA bundle after checking that it contains certificates
+func (h *HTTPService) SaveCACertificate(ctx context.Context, ca CACertificate) (*CACertificate, error) {
+	if strings.TrimSpace(ca.Host) == "" {
+		return nil, fmt.Errorf("a host is required")
+	}
+	if ca.ID == "" {
+		ca.ID = fmt.Sprintf("ca_%d", time.Now().UnixNano())
+	}
+	if ca.CreatedAt.IsZero() {
+		ca.CreatedAt = time.Now()
+	}
+
+	data, err := os.ReadFile(ca.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", ca.File)
+	}
+
+	err = h.certificates.update(func(settings *CertificateSettings) error {
+		for i, existing := range settings.CACertificates {
+			if existing.ID == ca.ID {
+				settings.CACertificates[i] = ca
+				return nil
+			}
+		}
+		settings.CACertificates = append(settings.CACertificates, ca)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.transports.clear()
+	return &ca, nil
+}
+
+// DeleteCACertificate removes a CA bundle
+func (h *HTTPService) DeleteCACertificate(ctx context.Context, id string) error {
+	err := h.certificates.update(func(settings *CertificateSettings) error {
+		for i, ca := range settings.CACertificates {
+			if ca.ID == id {
+				settings.CACertificates = append(settings.CACertificates[:i], settings.CACertificates[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("CA certificate %s not found", id)
+	})
+	if err != nil {
+		return err
+	}
+	h.transports.clear()
+	return nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// testPKI is a throwaway CA with a server certificate for 127.0.0.1 and a client certificate
+type testPKI struct {
+	dir        string
+	caFile     string
+	caPool     *x509.CertPool
+	caCert     *x509.Certificate
+	caKey      *ecdsa.PrivateKey
+	server     tls.Certificate
+	clientCert *x509.Certificate
+	clientKey  *ecdsa.PrivateKey
+	certFile   string
+	keyFile    string
+}
+
+// newTestPKI issues the certificates and writes the CA and client pair as PEM files
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	pki := &testPKI{dir: t.TempDir()}
+
+	caKey, caCert := issueCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	pki.caCert, pki.caKey = caCert, caKey
+	pki.caPool = x509.NewCertPool()
+	pki.caPool.AddCert(caCert)
+	pki.caFile = writePEM(t, pki.dir, "ca.pem", "CERTIFICATE", caCert.Raw)
+
+	serverKey, serverCert := issueCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, caKey)
+	pki.server = tls.Certificate{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}
+
+	pki.clientKey, pki.clientCert = issueCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "test client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, caKey)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(pki.clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki.certFile = writePEM(t, pki.dir, "client.pem", "CERTIFICATE", pki.clientCert.Raw)
+	pki.keyFile = writePEM(t, pki.dir, "client.key", "PRIVATE KEY", keyDER)
+	return pki
+}
+
+// issueCertificate creates a key and a certificate from template, self-signed when parent is nil
+func issueCertificate(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*ecdsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+// writePEM writes one PEM block to dir/name and returns the path
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newMTLSServer starts an HTTPS server that requires a client certificate issued by the test CA
+// and answers with the client's common name
+func newMTLSServer(t *testing.T, pki *testPKI) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.caPool,
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClientCertificateMTLS(t *testing.T) {
+	ctx := context.Background()
+	pki := newTestPKI(t)
+	srv := newMTLSServer(t, pki)
+	h := newTestHTTPService(t)
+	req := HTTPRequest{Method: http.MethodGet, URL: srv.URL}
+
+	if _, err := h.SendRequest(ctx, req); err == nil {
+		t.Fatal("request succeeded without trusting the test CA")
+	}
+
+	if _, err := h.SaveCACertificate(ctx, CACertificate{Host: "127.0.0.1", File: pki.caFile}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.SendRequest(ctx, req); err == nil {
+		t.Fatal("request succeeded without a client certificate")
+	}
+
+	host := srv.Listener.Addr().String()
+	if _, err := h.SaveClientCertificate(ctx, ClientCertificate{Host: host, CertFile: pki.certFile, KeyFile: pki.keyFile}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := h.SendRequest(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Body != "test client" {
+		t.Errorf("server saw client %q, want test client", resp.Body)
+	}
+	if resp.TLS == nil || !resp.TLS.Verified {
+		t.Errorf("TLS = %+v, want a verified connection", resp.TLS)
+	}
+}
+
+func TestClientCertificatePKCS12(t *testing.T) {
+	ctx := context.Background()
+	pki := newTestPKI(t)
+	srv := newMTLSServer(t, pki)
+	h := newTestHTTPService(t)
+
+	pfx, err := pkcs12.Modern.Encode(pki.clientKey, pki.clientCert, nil, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfxFile := filepath.Join(pki.dir, "client.p12")
+	if err := os.WriteFile(pfxFile, pfx, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.SaveClientCertificate(ctx, ClientCertificate{Host: "127.0.0.1", PFXFile: pfxFile, Passphrase: "wrong"}); err == nil {
+		t.Fatal("saved a PKCS#12 bundle with the wrong passphrase")
+	}
+	if _, err := h.SaveClientCertificate(ctx, ClientCertificate{Host: "127.0.0.1", PFXFile: pfxFile, Passphrase: "hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.SaveCACertificate(ctx, CACertificate{Host: "127.0.0.1", File: pki.caFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := h.SendRequest(ctx, HTTPRequest{Method: http.MethodGet, URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Body != "test client" {
+		t.Errorf("server saw client %q, want test client", resp.Body)
+	}
+}
+
+func TestClientCertificateNotSentAfterRedirect(t *testing.T) {
+	ctx := context.Background()
+	pki := newTestPKI(t)
+
+	// other records whether a certificate was presented without requiring one
+	other := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Write([]byte("certificate presented"))
+			return
+		}
+		w.Write([]byte("no certificate"))
+	}))
+	other.TLS = &tls.Config{Certificates: []tls.Certificate{pki.server}, ClientAuth: tls.RequestClientCert}
+	other.StartTLS()
+	t.Cleanup(other.Close)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL, http.StatusFound)
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.caPool,
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	h := newTestHTTPService(t)
+	host := srv.Listener.Addr().String()
+	if _, err := h.SaveClientCertificate(ctx, ClientCertificate{Host: host, CertFile: pki.certFile, KeyFile: pki.keyFile}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.SaveCACertificate(ctx, CACertificate{Host: "127.0.0.1", File: pki.caFile}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := h.SendRequest(ctx, HTTPRequest{Method: http.MethodGet, URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Redirects) != 1 {
+		t.Fatalf("redirects = %d, want 1", len(resp.Redirects))
+	}
+	if resp.Body != "no certificate" {
+		t.Errorf("redirect target got %q, want the certificate kept to %s", resp.Body, host)
+	}
+}
+
+func TestSaveCertificateRequiresHost(t *testing.T) {
+	ctx := context.Background()
+	pki := newTestPKI(t)
+	h := newTestHTTPService(t)
+
+	if _, err := h.SaveClientCertificate(ctx, ClientCertificate{Host: " ", CertFile: pki.certFile, KeyFile: pki.keyFile}); err == nil {
+		t.Error("saved a client certificate without a host")
+	}
+	if _, err := h.SaveCACertificate(ctx, CACertificate{File: pki.caFile}); err == nil {
+		t.Error("saved a CA bundle without a host")
+	}
+}
+
+func TestMatchHostPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		port    string
+		match   bool
+	}{
+		{"", "api.example.com", "443", false},
+		{"*", "api.example.com", "443", true},
+		{"api.example.com", "api.example.com", "443", true},
+		{"API.example.com", "api.example.com", "443", true},
+		{"api.example.com", "www.example.com", "443", false},
+		{"*.example.com", "api.example.com", "443", true},
+		{"*.example.com", "example.com", "443", false},
+		{"api.example.com:8443", "api.example.com", "8443", true},
+		{"api.example.com:8443", "api.example.com", "443", false},
+	}
+	for _, tt := range tests {
+		if got := matchHostPattern(tt.pattern, tt.host, tt.port) >= 0; got != tt.match {
+			t.Errorf("matchHostPattern(%q, %q, %q) matched = %v, want %v", tt.pattern, tt.host, tt.port, got, tt.match)
+		}
+	}
+
+	// More specific patterns must score higher
+	exact := matchHostPattern("api.example.com", "api.example.com", "443")
+	withPort := matchHostPattern("api.example.com:443", "api.example.com", "443")
+	wildcard := matchHostPattern("*.example.com", "api.example.com", "443")
+	anyHost := matchHostPattern("*", "api.example.com", "443")
+	if !(withPort > exact && exact > wildcard && wildcard > anyHost) {
+		t.Errorf("scores port=%d exact=%d wildcard=%d any=%d are not ordered by specificity", withPort, exact, wildcard, anyHost)
+	}
+}
+
+func TestClientCertificateReloadedWhenFilesChange(t *testing.T) {
+	ctx := context.Background()
+	pki := newTestPKI(t)
+	srv := newMTLSServer(t, pki)
+	h := newTestHTTPService(t)
+
+	host := srv.Listener.Addr().String()
+	if _, err := h.SaveClientCertificate(ctx, ClientCertificate{Host: host, CertFile: pki.certFile, KeyFile: pki.keyFile}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.SaveCACertificate(ctx, CACertificate{Host: "127.0.0.1", File: pki.caFile}); err != nil {
+		t.Fatal(err)
+	}
+	req := HTTPRequest{Method: http.MethodGet, URL: srv.URL}
+	if resp, err := h.SendRequest(ctx, req); err != nil || resp.Body != "test client" {
+		t.Fatalf("first send = %v, %v, want the original client", resp, err)
+	}
+
+	// Renew the certificate in place, as a tool rotating it on disk would
+	key, cert := issueCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "renewed client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, pki.caCert, pki.caKey)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, pki.dir, "client.pem", "CERTIFICATE", cert.Raw)
+	writePEM(t, pki.dir, "client.key", "PRIVATE KEY", keyDER)
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{pki.certFile, pki.keyFile} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := h.SendRequest(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Body != "renewed client" {
+		t.Errorf("server saw client %q after renewal, want renewed client", resp.Body)
+	}
+}
@@ -14,6 +14,8 @@ import (
 // CollectionService manages request collections
 type CollectionService struct {
 	collectionsPath string
+	globalsPath     string
+	secrets         *secretKeeper
 }
 
 // NewCollectionService creates a new collection service
@@ -27,29 +29,43 @@ func NewCollectionService() *CollectionService {
 
 	return &CollectionService{
 		collectionsPath: collectionsPath,
+		globalsPath:     filepath.Join(homeDir, ".captain-api", "globals.json"),
+		secrets:         newSecretKeeper(filepath.Join(homeDir, ".captain-api")),
 	}
 }
 
 // RequestItem represents a saved request
 type RequestItem struct {
-	ID          string            `json:"id"`
-	Name        string            `json:"name"`
-	Method      string            `json:"method"`
-	URL         string            `json:"url"`
-	Headers     map[string]string `json:"headers"`
-	Body        string            `json:"body"`
-	Description string            `json:"description"`
-	CreatedAt   time.Time         `json:"createdAt"`
-	UpdatedAt   time.Time         `json:"updatedAt"`
+	ID          string           `json:"id"`
+	Name        string           `json:"name"`
+	Method      string           `json:"method"`
+	URL         string           `json:"url"`
+	Params      []QueryParam     `json:"params,omitempty"`
+	PathParams  []PathParam      `json:"pathParams,omitempty"`
+	Headers     Headers          `json:"headers"`
+	Body        string           `json:"body"`
+	BodyMode    string           `json:"bodyMode,omitempty"`
+	URLEncoded  []KeyValue       `json:"urlencoded,omitempty"`
+	FormData    []FormField      `json:"formData,omitempty"`
+	BinaryFile  string           `json:"binaryFile,omitempty"`
+	GraphQL     *GraphQLBody     `json:"graphql,omitempty"`
+	Compression string           `json:"compression,omitempty"`
+	Variables   []Variable       `json:"variables,omitempty"`
+	Auth        *RequestAuth     `json:"auth,omitempty"`
+	Settings    *RequestSettings `json:"settings,omitempty"`
+	Description string           `json:"description"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	UpdatedAt   time.Time        `json:"updatedAt"`
 }
 
 // CollectionEnvironment represents an environment within a collection
 type CollectionEnvironment struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	BaseURL     string `json:"baseUrl"`
-	Description string `json:"description"`
-	IsActive    bool   `json:"isActive"`
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	BaseURL     string     `json:"baseUrl"`
+	Description string     `json:"description"`
+	Variables   []Variable `json:"variables"`
+	IsActive    bool       `json:"isActive"`
 }
 
 // HeaderCollection represents a collection of header templates
@@ -69,6 +85,10 @@ type Collection struct {
 	Name                     string                  `json:"name"`
 	Description              string                  `json:"description"`
 	ActiveHeaderCollectionID string                  `json:"activeHeaderCollectionId,omitempty"`
+	Variables                []Variable              `json:"variables"`
+	Auth                     *RequestAuth            `json:"auth,omitempty"`
+	Settings                 *RequestSettings        `json:"settings,omitempty"`
+	Proxy                    *ProxySettings          `json:"proxy,omitempty"` // overrides the application proxy when its mode is set
 	Environments             []CollectionEnvironment `json:"environments"`
 	HeaderCollections        []HeaderCollection      `json:"headerCollections"`
 	Requests                 []RequestItem           `json:"requests"`
@@ -260,6 +280,9 @@ func (c *CollectionService) saveCollection(collection *Collection) error {
 	slices.SortFunc(collection.Requests, func(a, b RequestItem) int {
 		return b.CreatedAt.Compare(a.CreatedAt) // Sort in descending order (newest first)
 	})
+	if err := c.sealCollectionSecrets(collection); err != nil {
+		return fmt.Errorf("failed to encrypt secrets: %w", err)
+	}
 	data, err := json.MarshalIndent(collection, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal collection: %w", err)
@@ -274,6 +297,55 @@ func (c *CollectionService) saveCollection(collection *Collection) error {
 	return nil
 }
 
+// sealCollectionSecrets encrypts every secret variable value and auth credential in the collection before it is written to disk
+func (c *CollectionService) sealCollectionSecrets(collection *Collection) error {
+	stored := c.storedCollectionSecrets(collection.ID)
+	if err := c.secrets.sealVariables(collection.Variables, stored); err != nil {
+		return err
+	}
+	for i := range collection.Environments {
+		if err := c.secrets.sealVariables(collection.Environments[i].Variables, stored); err != nil {
+			return err
+		}
+	}
+	for i := range collection.Requests {
+		if err := c.secrets.sealVariables(collection.Requests[i].Variables, stored); err != nil {
+			return err
+		}
+		if err := c.secrets.sealAuth(collection.Requests[i].Auth); err != nil {
+			return err
+		}
+	}
+	if err := c.secrets.sealAuth(collection.Auth); err != nil {
+		return err
+	}
+	if collection.Proxy != nil {
+		password, err := c.secrets.encrypt(collection.Proxy.Password)
+		if err != nil {
+			return err
+		}
+		collection.Proxy.Password = password
+	}
+	return nil
+}
+
+// storedCollectionSecrets returns the encrypted secret variable values of the collection as saved on disk
+func (c *CollectionService) storedCollectionSecrets(collectionID string) map[string]bool {
+	saved, err := c.GetCollection(context.Background(), collectionID)
+	if err != nil {
+		return nil
+	}
+
+	lists := [][]Variable{saved.Variables}
+	for _, env := range saved.Environments {
+		lists = append(lists, env.Variables)
+	}
+	for _, request := range saved.Requests {
+		lists = append(lists, request.Variables)
+	}
+	return storedSecrets(lists...)
+}
+
 // createDefaultEnvironments creates default environments for a new collection
 func (c *CollectionService) createDefaultEnvironments() []CollectionEnvironment {
 	return []CollectionEnvironment{
@@ -410,6 +482,10 @@ func (c *CollectionService) UpdateCollectionEnvironment(ctx context.Context, col
 		if existing.ID == env.ID {
 			// Preserve the IsActive state
 			env.IsActive = existing.IsActive
+			// Keep existing variables when the caller did not send any
+			if env.Variables == nil {
+				env.Variables = existing.Variables
+			}
 			collection.Environments[i] = env
 			collection.UpdatedAt = time.Now()
 			return c.saveCollection(collection)
@@ -559,3 +635,98 @@ func (c *CollectionService) DeleteHeaderCollection(ctx context.Context, collecti
 
 	return fmt.Errorf("header collection not found")
 }
+
+// UpdateCollectionVariables replaces the collection-level variables
+func (c *CollectionService) UpdateCollectionVariables(ctx context.Context, collectionID string, variables []Variable) error {
+	collection, err := c.GetCollection(ctx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	collection.Variables = variables
+	collection.UpdatedAt = time.Now()
+	return c.saveCollection(collection)
+}
+
+// UpdateCollectionAuth sets the auth inherited by the collection's requests; nil removes it
+func (c *CollectionService) UpdateCollectionAuth(ctx context.Context, collectionID string, auth *RequestAuth) error {
+	collection, err := c.GetCollection(ctx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	collection.Auth = auth
+	collection.UpdatedAt = time.Now()
+	return c.saveCollection(collection)
+}
+
+// UpdateCollectionSettings replaces the default request settings for a collection
+func (c *CollectionService) UpdateCollectionSettings(ctx context.Context, collectionID string, settings *RequestSettings) error {
+	collection, err := c.GetCollection(ctx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	collection.Settings = settings
+	collection.UpdatedAt = time.Now()
+	return c.saveCollection(collection)
+}
+
+// UpdateCollectionProxy sets the proxy for a collection; nil or an empty mode falls back to the application proxy
+func (c *CollectionService) UpdateCollectionProxy(ctx context.Context, collectionID string, proxy *ProxySettings) error {
+	if proxy != nil {
+		if err := proxy.validate(); err != nil {
+			return err
+		}
+	}
+
+	collection, err := c.GetCollection(ctx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	collection.Proxy = proxy
+	collection.UpdatedAt = time.Now()
+	return c.saveCollection(collection)
+}
+
+// GetGlobalVariables returns the variables shared by all collections
+func (c *CollectionService) GetGlobalVariables(ctx context.Context) ([]Variable, error) {
+	data, err := os.ReadFile(c.globalsPath)
+	if os.IsNotExist(err) {
+		return []Variable{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read global variables: %w", err)
+	}
+
+	var variables []Variable
+	if err := json.Unmarshal(data, &variables); err != nil {
+		return nil, fmt.Errorf("failed to parse global variables: %w", err)
+	}
+
+	return variables, nil
+}
+
+// SaveGlobalVariables replaces the variables shared by all collections
+func (c *CollectionService) SaveGlobalVariables(ctx context.Context, variables []Variable) error {
+	if variables == nil {
+		variables = []Variable{}
+	}
+
+	saved, _ := c.GetGlobalVariables(ctx)
+	if err := c.secrets.sealVariables(variables, storedSecrets(saved)); err != nil {
+		return fmt.Errorf("failed to encrypt secrets: %w", err)
+	}
+
+	data, err := json.MarshalIndent(variables, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal global variables: %w", err)
+	}
+
+	if err := os.WriteFile(c.globalsPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to save global variables: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings for HTTPRequest.Compression
+const (
+	CompressionGzip    = "gzip"
+	CompressionDeflate = "deflate"
+	CompressionBrotli  = "br"
+	CompressionZstd    = "zstd"
+)
+
+// acceptEncoding is sent when the request does not set Accept-Encoding itself
+const acceptEncoding = "gzip, deflate, br, zstd"
+
+// compress marks the body to be sent in the given content coding. The body is compressed
+// as it is sent, so its compressed length is not known up front and it goes out chunked.
+func (b *requestBody) compress(encoding string) error {
+	switch encoding {
+	case CompressionGzip, CompressionDeflate, CompressionBrotli, CompressionZstd:
+	default:
+		return fmt.Errorf("unsupported compression %q", encoding)
+	}
+	if b.size() == 0 {
+		return nil
+	}
+	b.contentEncoding = encoding
+	return nil
+}
+
+// compressReader returns a reader that compresses src in the given content coding as it is
+// read. Closing the reader stops the compression and closes src.
+func compressReader(src io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	w, err := newCompressor(pw, encoding)
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer src.Close()
+		_, err := io.Copy(w, src)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// newCompressor returns a writer encoding to w in the given content coding
+func newCompressor(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionDeflate:
+		// HTTP's deflate coding is the zlib format
+		return zlib.NewWriter(w), nil
+	case CompressionBrotli:
+		return brotli.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", encoding)
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read reads from the underlying reader, adding to the count
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// contentDecoder undoes the Content-Encoding of a response body. Decoders are created on
+// the first read so empty bodies and cancelled reads surface as normal read errors.
+type contentDecoder struct {
+	src       io.Reader
+	encodings []string
+	r         io.Reader
+	closers   []io.Closer
+}
+
+// decodeContent returns a reader decoding src according to a Content-Encoding header and
+// whether the body will be decoded. Bodies using a coding we cannot decode are returned as is.
+func decodeContent(src io.Reader, contentEncoding string) (io.ReadCloser, bool) {
+	var encodings []string
+	for _, e := range strings.Split(contentEncoding, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		switch e {
+		case "", "identity":
+		case CompressionGzip, "x-gzip", CompressionDeflate, CompressionBrotli, CompressionZstd:
+			encodings = append(encodings, e)
+		default:
+			return io.NopCloser(src), false
+		}
+	}
+	if len(encodings) == 0 {
+		return io.NopCloser(src), true
+	}
+	return &contentDecoder{src: src, encodings: encodings}, true
+}
+
+// Read decodes the next bytes of the body
+func (d *contentDecoder) Read(p []byte) (int, error) {
+	if d.r == nil {
+		if err := d.init(); err != nil {
+			return 0, err
+		}
+	}
+	return d.r.Read(p)
+}
+
+// init stacks a decoder for each coding, the last applied coding first
+func (d *contentDecoder) init() error {
+	r := d.src
+	for i := len(d.encodings) - 1; i >= 0; i-- {
+		buffered := bufio.NewReader(r)
+		header, err := buffered.Peek(2)
+		if len(header) == 0 {
+			if err == io.EOF {
+				d.r = bytes.NewReader(nil)
+				return nil
+			}
+			return err
+		}
+
+		switch d.encodings[i] {
+		case CompressionGzip, "x-gzip":
+			gz, err := gzip.NewReader(buffered)
+			if err != nil {
+				return err
+			}
+			d.closers = append(d.closers, gz)
+			r = gz
+		case CompressionDeflate:
+			// Some servers send raw deflate data instead of the zlib format
+			var fr io.ReadCloser
+			if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+				fr, err = zlib.NewReader(buffered)
+				if err != nil {
+					return err
+				}
+			} else {
+				fr = flate.NewReader(buffered)
+			}
+			d.closers = append(d.closers, fr)
+			r = fr
+		case CompressionBrotli:
+			r = brotli.NewReader(buffered)
+		case CompressionZstd:
+			zr, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return err
+			}
+			d.closers = append(d.closers, zr.IOReadCloser())
+			r = zr
+		}
+	}
+	d.r = r
+	return nil
+}
+
+// Close releases the decoders
+func (d *contentDecoder) Close() error {
+	for _, c := range d.closers {
+		c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Cookie is a stored cookie. Domain has no leading dot; HostOnly cookies are
+// only sent to exactly that host. A zero Expires marks a session cookie, which
+// is kept until it is deleted.
+type Cookie struct {
+	Name      string    `json:"name"`
+	Value     string    `json:"value"`
+	Domain    string    `json:"domain"`
+	Path      string    `json:"path"`
+	Expires   time.Time `json:"expires,omitempty"`
+	Secure    bool      `json:"secure"`
+	HttpOnly  bool      `json:"httpOnly"`
+	SameSite  string    `json:"sameSite,omitempty"`
+	HostOnly  bool      `json:"hostOnly"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// expired reports whether the cookie has a past expiry
+func (c *Cookie) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !c.Expires.After(now)
+}
+
+// sameIdentity reports whether two cookies replace each other
+func (c *Cookie) sameIdentity(other *Cookie) bool {
+	return c.Name == other.Name && c.Domain == other.Domain && c.Path == other.Path
+}
+
+// cookieJar is a persistent http.CookieJar for one collection and environment
+type cookieJar struct {
+	mu      sync.Mutex
+	path    string
+	cookies []Cookie
+}
+
+// SetCookies stores the cookies a response from u set, following RFC 6265 domain and path rules.
+// A Domain attribute naming a public suffix such as co.uk is rejected unless it is the host itself,
+// in which case the cookie is kept host-only.
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	host := canonicalCookieHost(u.Hostname())
+	changed := false
+
+	for _, c := range cookies {
+		stored := Cookie{
+			Name:      c.Name,
+			Value:     c.Value,
+			Domain:    host,
+			Path:      c.Path,
+			Secure:    c.Secure,
+			HttpOnly:  c.HttpOnly,
+			SameSite:  sameSiteName(c.SameSite),
+			HostOnly:  true,
+			CreatedAt: now,
+		}
+
+		if c.Domain != "" {
+			domain := canonicalCookieHost(strings.TrimPrefix(c.Domain, "."))
+			if !domainMatch(host, domain) {
+				continue
+			}
+			// IP addresses cannot share cookies with other hosts
+			if net.ParseIP(host) != nil && domain != host {
+				continue
+			}
+			if suffix, _ := publicsuffix.PublicSuffix(domain); suffix == domain {
+				if domain != host {
+					continue
+				}
+			} else {
+				stored.Domain = domain
+				stored.HostOnly = false
+			}
+		}
+		if !strings.HasPrefix(stored.Path, "/") {
+			stored.Path = defaultCookiePath(u.Path)
+		}
+
+		switch {
+		case c.MaxAge < 0:
+			stored.Expires = now
+		case c.MaxAge > 0:
+			stored.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			stored.Expires = c.Expires
+		}
+
+		j.put(stored, now)
+		changed = true
+	}
+
+	if changed {
+		if err := j.save(); err != nil {
+			fmt.Printf("Warning: failed to save cookies: %v\n", err)
+		}
+	}
+}
+
+// Cookies returns the cookies to send to u, longest path first
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	host := canonicalCookieHost(u.Hostname())
+	requestPath := u.EscapedPath()
+	if requestPath == "" {
+		requestPath = "/"
+	}
+	secure := u.Scheme == "https" || u.Scheme == "wss"
+
+	var matched []Cookie
+	for _, c := range j.cookies {
+		if c.expired(now) || (c.Secure && !secure) || !pathMatch(requestPath, c.Path) {
+			continue
+		}
+		if c.HostOnly && host != c.Domain || !c.HostOnly && !domainMatch(host, c.Domain) {
+			continue
+		}
+		matched = append(matched, c)
+	}
+
+	sort.SliceStable(matched, func(a, b int) bool {
+		if len(matched[a].Path) != len(matched[b].Path) {
+			return len(matched[a].Path) > len(matched[b].Path)
+		}
+		return matched[a].CreatedAt.Before(matched[b].CreatedAt)
+	})
+
+	cookies := make([]*http.Cookie, len(matched))
+	for i, c := range matched {
+		cookies[i] = &http.Cookie{Name: c.Name, Value: c.Value}
+	}
+	return cookies
+}
+
+// put replaces a cookie with the same name, domain and path, or adds it; expired cookies are removed
+func (j *cookieJar) put(cookie Cookie, now time.Time) {
+	for i := range j.cookies {
+		if !j.cookies[i].sameIdentity(&cookie) {
+			continue
+		}
+		if cookie.expired(now) {
+			j.cookies = append(j.cookies[:i], j.cookies[i+1:]...)
+			return
+		}
+		cookie.CreatedAt = j.cookies[i].CreatedAt
+		j.cookies[i] = cookie
+		return
+	}
+	if !cookie.expired(now) {
+		j.cookies = append(j.cookies, cookie)
+	}
+}
+
+// save writes the jar, leaving out expired cookies
+func (j *cookieJar) save() error {
+	now := time.Now()
+	live := make([]Cookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		if !c.expired(now) {
+			live = append(live, c)
+		}
+	}
+	j.cookies = live
+
+	data, err := json.MarshalIndent(j.cookies, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(j.path, data, 0600)
+}
+
+// cookieStore keeps one persistent jar per collection and environment
+type cookieStore struct {
+	mu   sync.Mutex
+	dir  string
+	jars map[string]*cookieJar
+}
+
+// newCookieStore creates a store saving jars under ~/.captain-api/cookies
+func newCookieStore() *cookieStore {
+	homeDir, _ := os.UserHomeDir()
+	dir := filepath.Join(homeDir, ".captain-api", "cookies")
+	os.MkdirAll(dir, 0755)
+
+	return &cookieStore{
+		dir:  dir,
+		jars: make(map[string]*cookieJar),
+	}
+}
+
+// jar returns the jar for scope, loading it from disk on first use
+func (s *cookieStore) jar(scope string) *cookieJar {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if jar, ok := s.jars[scope]; ok {
+		return jar
+	}
+
+	name := strings.ReplaceAll(scope, "/", "_")
+	if name == "" {
+		name = "_default"
+	}
+	jar := &cookieJar{path: filepath.Join(s.dir, filepath.Base(name)+".json")}
+
+	if data, err := os.ReadFile(jar.path); err == nil {
+		if err := json.Unmarshal(data, &jar.cookies); err != nil {
+			fmt.Printf("Warning: failed to parse cookies %s: %v\n", jar.path, err)
+		}
+	}
+
+	s.jars[scope] = jar
+	return jar
+}
+
+// GetCookies returns the cookies stored for a collection's active environment, optionally only
+// those for domain and its subdomains
+func (h *HTTPService) GetCookies(ctx context.Context, collectionID string, domain string) ([]Cookie, error) {
+	jar := h.cookies.jar(h.tokenScope(ctx, collectionID))
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+
+	now := time.Now()
+	domain = canonicalCookieHost(strings.TrimPrefix(domain, "."))
+	cookies := []Cookie{}
+	for _, c := range jar.cookies {
+		if c.expired(now) || domain != "" && !domainMatch(c.Domain, domain) {
+			continue
+		}
+		cookies = append(cookies, c)
+	}
+
+	sort.SliceStable(cookies, func(a, b int) bool {
+		if cookies[a].Domain != cookies[b].Domain {
+			return cookies[a].Domain < cookies[b].Domain
+		}
+		return cookies[a].Name < cookies[b].Name
+	})
+	return cookies, nil
+}
+
+// SaveCookie adds a cookie or replaces the one with the same name, domain and path
+func (h *HTTPService) SaveCookie(ctx context.Context, collectionID string, cookie Cookie) error {
+	if cookie.Name == "" {
+		return fmt.Errorf("cookie name is required")
+	}
+	cookie.Domain = canonicalCookieHost(strings.TrimPrefix(cookie.Domain, "."))
+	if cookie.Domain == "" {
+		return fmt.Errorf("cookie domain is required")
+	}
+	if !strings.HasPrefix(cookie.Path, "/") {
+		cookie.Path = "/"
+	}
+	if cookie.CreatedAt.IsZero() {
+		cookie.CreatedAt = time.Now()
+	}
+
+	jar := h.cookies.jar(h.tokenScope(ctx, collectionID))
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+
+	jar.put(cookie, time.Now())
+	return jar.save()
+}
+
+// DeleteCookie removes the cookie with the given domain, path and name
+func (h *HTTPService) DeleteCookie(ctx context.Context, collectionID string, domain string, cookiePath string, name string) error {
+	jar := h.cookies.jar(h.tokenScope(ctx, collectionID))
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+
+	target := Cookie{Name: name, Domain: canonicalCookieHost(strings.TrimPrefix(domain, ".")), Path: cookiePath}
+	for i := range jar.cookies {
+		if jar.cookies[i].sameIdentity(&target) {
+			jar.cookies = append(jar.cookies[:i], jar.cookies[i+1:]...)
+			return jar.save()
+		}
+	}
+	return fmt.Errorf("cookie %s not found for %s%s", name, target.Domain, cookiePath)
+}
+
+// ClearCookies removes every cookie for domain and its subdomains, or all cookies when domain is empty
+func (h *HTTPService) ClearCookies(ctx context.Context, collectionID string, domain string) error {
+	jar := h.cookies.jar(h.tokenScope(ctx, collectionID))
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+
+	domain = canonicalCookieHost(strings.TrimPrefix(domain, "."))
+	kept := []Cookie{}
+	for _, c := range jar.cookies {
+		if domain != "" && !domainMatch(c.Domain, domain) {
+			kept = append(kept, c)
+		}
+	}
+	jar.cookies = kept
+	return jar.save()
+}
+
+// canonicalCookieHost lower-cases a host and strips a trailing dot
+func canonicalCookieHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// domainMatch reports whether host is domain or one of its subdomains
+func domainMatch(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// pathMatch reports whether a request path falls under a cookie path
+func pathMatch(requestPath, cookiePath string) bool {
+	if requestPath == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || requestPath[len(cookiePath)] == '/'
+}
+
+// defaultCookiePath is the directory of the request path, as used when Set-Cookie has no Path
+func defaultCookiePath(requestPath string) string {
+	if !strings.HasPrefix(requestPath, "/") || strings.Count(requestPath, "/") == 1 {
+		return "/"
+	}
+	return path.Dir(requestPath)
+}
+
+// sameSiteName returns the attribute value for a SameSite mode
+func sameSiteName(mode http.SameSite) string {
+	switch mode {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	}
+	return ""
+}
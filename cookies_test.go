@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetCookiesPublicSuffix(t *testing.T) {
+	jar := &cookieJar{path: filepath.Join(t.TempDir(), "cookies.json")}
+	set := func(rawURL string, cookies ...*http.Cookie) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jar.SetCookies(u, cookies)
+	}
+
+	set("https://shop.example.co.uk/", &http.Cookie{Name: "tracker", Value: "1", Domain: "co.uk"})
+	set("https://shop.example.co.uk/", &http.Cookie{Name: "session", Value: "2", Domain: "example.co.uk"})
+	set("https://foo.github.io/", &http.Cookie{Name: "pages", Value: "3", Domain: "github.io"})
+	set("https://github.io/", &http.Cookie{Name: "apex", Value: "4", Domain: "github.io"})
+
+	stored := make(map[string]Cookie)
+	for _, c := range jar.cookies {
+		stored[c.Name] = c
+	}
+	if _, ok := stored["tracker"]; ok {
+		t.Error("stored a cookie for the public suffix co.uk")
+	}
+	if _, ok := stored["pages"]; ok {
+		t.Error("stored a cookie for the public suffix github.io from a subdomain")
+	}
+	if c, ok := stored["session"]; !ok || c.Domain != "example.co.uk" || c.HostOnly {
+		t.Errorf("session cookie = %+v, want a domain cookie for example.co.uk", c)
+	}
+	if c, ok := stored["apex"]; !ok || c.Domain != "github.io" || !c.HostOnly {
+		t.Errorf("apex cookie = %+v, want a host-only cookie for github.io", c)
+	}
+
+	u, _ := url.Parse("https://other.example.co.uk/")
+	if got := jar.Cookies(u); len(got) != 1 || got[0].Name != "session" {
+		t.Errorf("cookies for a sibling host = %v, want only session", got)
+	}
+}
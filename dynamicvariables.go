@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DynamicVariableFunc generates the value of a {{$name arg...}} placeholder.
+// args holds the whitespace-separated arguments following the name and vars
+// holds the other variables resolved for the request.
+type DynamicVariableFunc func(args []string, vars map[string]string) (string, error)
+
+// GeneratedVariable records a dynamic value produced while preparing a request
+type GeneratedVariable struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+var (
+	dynamicVariablesMu sync.RWMutex
+	dynamicVariables   = map[string]DynamicVariableFunc{}
+)
+
+func init() {
+	RegisterDynamicVariable("uuid", dynamicUUID)
+	RegisterDynamicVariable("guid", dynamicUUID)
+	RegisterDynamicVariable("timestamp", dynamicTimestamp)
+	RegisterDynamicVariable("isoTimestamp", dynamicISOTimestamp)
+	RegisterDynamicVariable("randomInt", dynamicRandomInt)
+	RegisterDynamicVariable("randomString", dynamicRandomString)
+	RegisterDynamicVariable("randomEmail", dynamicRandomEmail)
+	RegisterDynamicVariable("base64", dynamicBase64)
+}
+
+// RegisterDynamicVariable makes fn available as {{$name}}, replacing any generator already registered under that name
+func RegisterDynamicVariable(name string, fn DynamicVariableFunc) {
+	dynamicVariablesMu.Lock()
+	defer dynamicVariablesMu.Unlock()
+
+	dynamicVariables[strings.TrimPrefix(name, "$")] = fn
+}
+
+// lookupDynamicVariable returns the generator registered under name, if any
+func lookupDynamicVariable(name string) (DynamicVariableFunc, bool) {
+	dynamicVariablesMu.RLock()
+	defer dynamicVariablesMu.RUnlock()
+
+	fn, ok := dynamicVariables[name]
+	return fn, ok
+}
+
+// dynamicUUID generates a random version 4 UUID
+func dynamicUUID(args []string, vars map[string]string) (string, error) {
+	return uuid.NewString(), nil
+}
+
+// dynamicTimestamp returns the current Unix time in seconds
+func dynamicTimestamp(args []string, vars map[string]string) (string, error) {
+	return strconv.FormatInt(time.Now().Unix(), 10), nil
+}
+
+// dynamicISOTimestamp returns the current time in ISO 8601 format (UTC)
+func dynamicISOTimestamp(args []string, vars map[string]string) (string, error) {
+	return time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), nil
+}
+
+// dynamicRandomInt returns a random integer in [min, max], defaulting to [0, 1000]
+func dynamicRandomInt(args []string, vars map[string]string) (string, error) {
+	min, max := int64(0), int64(1000)
+	if len(args) > 0 {
+		if len(args) != 2 {
+			return "", fmt.Errorf("$randomInt expects a min and max, got %d arguments", len(args))
+		}
+		var err error
+		if min, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+			return "", fmt.Errorf("$randomInt: invalid min %q", args[0])
+		}
+		if max, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return "", fmt.Errorf("$randomInt: invalid max %q", args[1])
+		}
+		if min > max {
+			return "", fmt.Errorf("$randomInt: min %d is greater than max %d", min, max)
+		}
+	}
+
+	// Work in uint64 so ranges wider than MaxInt64 do not overflow; a width of 0
+	// means the range covers every int64
+	offset := rand.Uint64()
+	if width := uint64(max) - uint64(min) + 1; width != 0 {
+		offset = rand.Uint64N(width)
+	}
+	return strconv.FormatInt(int64(uint64(min)+offset), 10), nil
+}
+
+// maxRandomStringLength caps $randomString so a typo cannot allocate an enormous value
+const maxRandomStringLength = 4096
+
+// dynamicRandomString returns a random alphanumeric string, 16 characters long unless a length is given
+func dynamicRandomString(args []string, vars map[string]string) (string, error) {
+	length := 16
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return "", fmt.Errorf("$randomString: invalid length %q", args[0])
+		}
+		if n > maxRandomStringLength {
+			return "", fmt.Errorf("$randomString: length %d is over the maximum of %d", n, maxRandomStringLength)
+		}
+		length = n
+	}
+
+	return randomAlphanumeric(length), nil
+}
+
+// dynamicRandomEmail returns a random address under example.com
+func dynamicRandomEmail(args []string, vars map[string]string) (string, error) {
+	return strings.ToLower(randomAlphanumeric(10)) + "@example.com", nil
+}
+
+// dynamicBase64 returns the standard base64 encoding of another variable's value
+func dynamicBase64(args []string, vars map[string]string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("$base64 expects the name of a variable")
+	}
+
+	value, ok := vars[args[0]]
+	if !ok {
+		return "", fmt.Errorf("$base64: variable %q is not defined", args[0])
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(value)), nil
+}
+
+// randomAlphanumeric returns n random characters from [A-Za-z0-9]
+func randomAlphanumeric(n int) string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = alphabet[rand.IntN(len(alphabet))]
+	}
+	return string(b)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestDynamicRandomStringLength(t *testing.T) {
+	tests := []struct {
+		arg     string
+		length  int
+		wantErr bool
+	}{
+		{"", 16, false},
+		{"1", 1, false},
+		{strconv.Itoa(maxRandomStringLength), maxRandomStringLength, false},
+		{strconv.Itoa(maxRandomStringLength + 1), 0, true},
+		{"999999999999", 0, true},
+		{"0", 0, true},
+		{"abc", 0, true},
+	}
+	for _, tt := range tests {
+		var args []string
+		if tt.arg != "" {
+			args = []string{tt.arg}
+		}
+		got, err := dynamicRandomString(args, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("dynamicRandomString(%q) error = %v, want error %v", tt.arg, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && len(got) != tt.length {
+			t.Errorf("dynamicRandomString(%q) length = %d, want %d", tt.arg, len(got), tt.length)
+		}
+	}
+}
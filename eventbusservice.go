@@ -1,7 +1,5 @@
 package main
 
-import "fmt"
-
 type customEvent struct {
 	Name string
 	Data any
@@ -18,7 +16,6 @@ func NewEventBusService(eventChannel chan customEvent) *EventBusService {
 }
 
 func (e *EventBusService) EmitEvent(name string, data any) {
-	fmt.Println("Emitting event:", name, data)
 	e.eventChannel <- customEvent{
 		Name: name,
 		Data: data,
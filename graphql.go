@@ -0,0 +1,721 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// BodyModeGraphQL sends HTTPRequest.GraphQL as a GraphQL-over-HTTP JSON body
+const BodyModeGraphQL = "graphql"
+
+// graphQLIntrospectionQuery fetches the parts of the schema used for completion and validation
+const graphQLIntrospectionQuery = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types {
+      kind
+      name
+      description
+      fields(includeDeprecated: true) {
+        name
+        description
+        args { name type { ...TypeRef } }
+        type { ...TypeRef }
+      }
+      inputFields { name type { ...TypeRef } }
+      enumValues(includeDeprecated: true) { name }
+      possibleTypes { name }
+    }
+  }
+}
+
+fragment TypeRef on __Type {
+  kind
+  name
+  ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } }
+}`
+
+// GraphQLBody holds the parts of a GraphQL request
+type GraphQLBody struct {
+	Query         string `json:"query"`
+	Variables     string `json:"variables,omitempty"` // JSON object
+	OperationName string `json:"operationName,omitempty"`
+}
+
+// GraphQLSchema is a simplified introspection result used for autocompletion and validation
+type GraphQLSchema struct {
+	QueryType        string        `json:"queryType"`
+	MutationType     string        `json:"mutationType,omitempty"`
+	SubscriptionType string        `json:"subscriptionType,omitempty"`
+	Types            []GraphQLType `json:"types"`
+	URL              string        `json:"url"`
+	FetchedAt        time.Time     `json:"fetchedAt"`
+}
+
+// GraphQLType describes a named schema type
+type GraphQLType struct {
+	Name          string         `json:"name"`
+	Kind          string         `json:"kind"`
+	Description   string         `json:"description,omitempty"`
+	Fields        []GraphQLField `json:"fields,omitempty"`
+	InputFields   []GraphQLField `json:"inputFields,omitempty"`
+	EnumValues    []string       `json:"enumValues,omitempty"`
+	PossibleTypes []string       `json:"possibleTypes,omitempty"`
+}
+
+// GraphQLField describes a field or argument; Type is rendered in SDL notation such as [User!]!
+type GraphQLField struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Type        string         `json:"type"`
+	Args        []GraphQLField `json:"args,omitempty"`
+}
+
+// graphQLTypeRef is the recursive type reference returned by introspection
+type graphQLTypeRef struct {
+	Kind   string          `json:"kind"`
+	Name   string          `json:"name"`
+	OfType *graphQLTypeRef `json:"ofType"`
+}
+
+// String renders the reference in SDL notation
+func (t *graphQLTypeRef) String() string {
+	if t == nil {
+		return ""
+	}
+	switch t.Kind {
+	case "NON_NULL":
+		return t.OfType.String() + "!"
+	case "LIST":
+		return "[" + t.OfType.String() + "]"
+	}
+	return t.Name
+}
+
+// graphQLSchemaStore caches introspected schemas per collection in memory and on disk
+type graphQLSchemaStore struct {
+	mu      sync.RWMutex
+	dir     string
+	schemas map[string]*GraphQLSchema
+}
+
+// newGraphQLSchemaStore creates a store persisting schemas under ~/.captain-api/graphql
+func newGraphQLSchemaStore() *graphQLSchemaStore {
+	homeDir, _ := os.UserHomeDir()
+	dir := filepath.Join(homeDir, ".captain-api", "graphql")
+	os.MkdirAll(dir, 0755)
+
+	return &graphQLSchemaStore{
+		dir:     dir,
+		schemas: make(map[string]*GraphQLSchema),
+	}
+}
+
+// get returns the cached schema for a collection, loading it from disk if needed.
+// It returns nil when no schema has been cached.
+func (s *graphQLSchemaStore) get(collectionID string) (*GraphQLSchema, error) {
+	key, err := s.key(collectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	schema, ok := s.schemas[key]
+	s.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, key+".json"))
+	if err != nil {
+		return nil, nil
+	}
+	schema = &GraphQLSchema{}
+	if err := json.Unmarshal(data, schema); err != nil {
+		fmt.Printf("Error parsing cached GraphQL schema: %v\n", err)
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	s.schemas[key] = schema
+	s.mu.Unlock()
+	return schema, nil
+}
+
+// put caches a schema for a collection
+func (s *graphQLSchemaStore) put(collectionID string, schema *GraphQLSchema) error {
+	key, err := s.key(collectionID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.schemas[key] = schema
+	s.mu.Unlock()
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	return os.WriteFile(filepath.Join(s.dir, key+".json"), data, 0644)
+}
+
+// key maps requests without a collection onto a shared cache entry. The key names a file in
+// the store, so collection IDs that are not a plain file name are rejected.
+func (s *graphQLSchemaStore) key(collectionID string) (string, error) {
+	if collectionID == "" {
+		return "_default", nil
+	}
+	if collectionID == "." || collectionID == ".." || strings.ContainsAny(collectionID, `/\:`) ||
+		filepath.Base(collectionID) != collectionID {
+		return "", fmt.Errorf("invalid collection ID %q", collectionID)
+	}
+	return collectionID, nil
+}
+
+// expandGraphQL substitutes variables in the GraphQL query, variables and operation name
+func expandGraphQL(body *GraphQLBody, r *variableResolver) *GraphQLBody {
+	if body == nil {
+		return nil
+	}
+	return &GraphQLBody{
+		Query:         r.expand(body.Query),
+		Variables:     r.expand(body.Variables),
+		OperationName: r.expand(body.OperationName),
+	}
+}
+
+// buildGraphQLBody serializes a GraphQL request as JSON
+func buildGraphQLBody(body *GraphQLBody) (*requestBody, error) {
+	if body == nil || strings.TrimSpace(body.Query) == "" {
+		return nil, fmt.Errorf("GraphQL body requires a query")
+	}
+
+	payload := map[string]any{"query": body.Query}
+	if strings.TrimSpace(body.Variables) != "" {
+		var variables map[string]any
+		if err := json.Unmarshal([]byte(body.Variables), &variables); err != nil {
+			return nil, fmt.Errorf("GraphQL variables must be a JSON object: %w", err)
+		}
+		payload["variables"] = variables
+	}
+	if body.OperationName != "" {
+		payload["operationName"] = body.OperationName
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal GraphQL body: %w", err)
+	}
+
+	return &requestBody{
+		segments:    []bodySegment{{data: data, size: int64(len(data))}},
+		contentType: "application/json",
+		summary:     string(data),
+	}, nil
+}
+
+// IntrospectSchema runs the introspection query against the request's endpoint and caches the
+// schema for the request's collection. Auth, variables and headers are applied as for SendRequest.
+func (h *HTTPService) IntrospectSchema(ctx context.Context, req HTTPRequest) (*GraphQLSchema, error) {
+	if _, err := h.graphqlSchemas.key(req.CollectionID); err != nil {
+		return nil, err
+	}
+	req.Method = "POST"
+	req.BodyMode = BodyModeGraphQL
+	req.GraphQL = &GraphQLBody{Query: graphQLIntrospectionQuery, OperationName: "IntrospectionQuery"}
+
+	resp, err := h.SendRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("introspection failed with status %s", resp.Status)
+	}
+
+	var result struct {
+		Data struct {
+			Schema struct {
+				QueryType        *struct{ Name string } `json:"queryType"`
+				MutationType     *struct{ Name string } `json:"mutationType"`
+				SubscriptionType *struct{ Name string } `json:"subscriptionType"`
+				Types            []struct {
+					Kind        string `json:"kind"`
+					Name        string `json:"name"`
+					Description string `json:"description"`
+					Fields      []struct {
+						Name        string `json:"name"`
+						Description string `json:"description"`
+						Args        []struct {
+							Name string          `json:"name"`
+							Type *graphQLTypeRef `json:"type"`
+						} `json:"args"`
+						Type *graphQLTypeRef `json:"type"`
+					} `json:"fields"`
+					InputFields []struct {
+						Name string          `json:"name"`
+						Type *graphQLTypeRef `json:"type"`
+					} `json:"inputFields"`
+					EnumValues    []struct{ Name string } `json:"enumValues"`
+					PossibleTypes []struct{ Name string } `json:"possibleTypes"`
+				} `json:"types"`
+			} `json:"__schema"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	body, err := h.fullResponseBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read introspection response: %w", err)
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse introspection response: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		return nil, fmt.Errorf("introspection failed: %s", result.Errors[0].Message)
+	}
+	if result.Data.Schema.QueryType == nil {
+		return nil, fmt.Errorf("introspection response did not contain a schema")
+	}
+
+	raw := result.Data.Schema
+	schema := &GraphQLSchema{
+		QueryType: raw.QueryType.Name,
+		URL:       req.URL,
+		FetchedAt: time.Now(),
+	}
+	if raw.MutationType != nil {
+		schema.MutationType = raw.MutationType.Name
+	}
+	if raw.SubscriptionType != nil {
+		schema.SubscriptionType = raw.SubscriptionType.Name
+	}
+
+	for _, t := range raw.Types {
+		gt := GraphQLType{Name: t.Name, Kind: t.Kind, Description: t.Description}
+		for _, f := range t.Fields {
+			field := GraphQLField{Name: f.Name, Description: f.Description, Type: f.Type.String()}
+			for _, a := range f.Args {
+				field.Args = append(field.Args, GraphQLField{Name: a.Name, Type: a.Type.String()})
+			}
+			gt.Fields = append(gt.Fields, field)
+		}
+		for _, f := range t.InputFields {
+			gt.InputFields = append(gt.InputFields, GraphQLField{Name: f.Name, Type: f.Type.String()})
+		}
+		for _, v := range t.EnumValues {
+			gt.EnumValues = append(gt.EnumValues, v.Name)
+		}
+		for _, p := range t.PossibleTypes {
+			gt.PossibleTypes = append(gt.PossibleTypes, p.Name)
+		}
+		schema.Types = append(schema.Types, gt)
+	}
+
+	if err := h.graphqlSchemas.put(req.CollectionID, schema); err != nil {
+		fmt.Printf("Warning: failed to cache GraphQL schema: %v\n", err)
+	}
+
+	return schema, nil
+}
+
+// GetGraphQLSchema returns the cached schema for a collection, or nil if it has not been introspected
+func (h *HTTPService) GetGraphQLSchema(ctx context.Context, collectionID string) (*GraphQLSchema, error) {
+	return h.graphqlSchemas.get(collectionID)
+}
+
+// ValidateGraphQLQuery checks a query against the collection's cached schema and returns the problems found.
+// Without a cached schema only the syntax is checked.
+func (h *HTTPService) ValidateGraphQLQuery(ctx context.Context, collectionID string, query string) ([]string, error) {
+	schema, err := h.graphqlSchemas.get(collectionID)
+	if err != nil {
+		return nil, err
+	}
+	return validateGraphQLQuery(query, schema), nil
+}
+
+// validateGraphQLQuery parses the query and checks selected fields against schema when one is given
+func validateGraphQLQuery(query string, schema *GraphQLSchema) []string {
+	doc, err := parseGraphQLDocument(query)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if schema == nil {
+		return nil
+	}
+
+	types := make(map[string]*GraphQLType, len(schema.Types))
+	for i := range schema.Types {
+		types[schema.Types[i].Name] = &schema.Types[i]
+	}
+
+	var problems []string
+	var check func(typeName string, selections []graphQLSelection, path string)
+	check = func(typeName string, selections []graphQLSelection, path string) {
+		// Introspection types such as __Type are not part of the introspected schema
+		if strings.HasPrefix(typeName, "__") {
+			return
+		}
+		parent := types[typeName]
+		if parent == nil {
+			problems = append(problems, fmt.Sprintf("unknown type %q", typeName))
+			return
+		}
+
+		for _, sel := range selections {
+			switch {
+			case sel.fragmentSpread != "":
+				// Fragment definitions are checked against their own type condition
+			case sel.inlineType != "" || sel.field == "":
+				target := sel.inlineType
+				if target == "" {
+					target = typeName
+				}
+				check(target, sel.children, path)
+			case strings.HasPrefix(sel.field, "__"):
+				// Introspection fields such as __typename and __schema are always available
+			default:
+				var field *GraphQLField
+				for i := range parent.Fields {
+					if parent.Fields[i].Name == sel.field {
+						field = &parent.Fields[i]
+						break
+					}
+				}
+				fieldPath := strings.TrimPrefix(path+"."+sel.field, ".")
+				if field == nil {
+					problems = append(problems, fmt.Sprintf("field %q does not exist on type %s", fieldPath, typeName))
+					continue
+				}
+
+				fieldType := types[strings.Trim(field.Type, "[]!")]
+				composite := fieldType != nil && (fieldType.Kind == "OBJECT" || fieldType.Kind == "INTERFACE" || fieldType.Kind == "UNION")
+				switch {
+				case composite && len(sel.children) == 0:
+					problems = append(problems, fmt.Sprintf("field %q of type %s must have a selection of subfields", fieldPath, field.Type))
+				case !composite && len(sel.children) > 0:
+					problems = append(problems, fmt.Sprintf("field %q of type %s cannot have a selection of subfields", fieldPath, field.Type))
+				case composite:
+					check(fieldType.Name, sel.children, fieldPath)
+				}
+			}
+		}
+	}
+
+	for _, op := range doc.operations {
+		var root string
+		switch op.kind {
+		case "query":
+			root = schema.QueryType
+		case "mutation":
+			root = schema.MutationType
+		case "subscription":
+			root = schema.SubscriptionType
+		case "fragment":
+			root = op.typeCondition
+		}
+		if root == "" {
+			problems = append(problems, fmt.Sprintf("schema does not support %s operations", op.kind))
+			continue
+		}
+		check(root, op.selections, "")
+	}
+
+	return problems
+}
+
+// graphQLDocument is the subset of an executable document needed for validation
+type graphQLDocument struct {
+	operations []graphQLOperation
+}
+
+// graphQLOperation is an operation or fragment definition
+type graphQLOperation struct {
+	kind          string // query, mutation, subscription or fragment
+	typeCondition string // fragments only
+	selections    []graphQLSelection
+}
+
+// graphQLSelection is a field, fragment spread or inline fragment
+type graphQLSelection struct {
+	field          string
+	fragmentSpread string
+	inlineType     string
+	children       []graphQLSelection
+}
+
+// graphQLParser is a small recursive descent parser over GraphQL tokens
+type graphQLParser struct {
+	tokens []string
+	pos    int
+}
+
+// parseGraphQLDocument parses operations and fragments, skipping arguments, variables and directives
+func parseGraphQLDocument(query string) (*graphQLDocument, error) {
+	tokens, err := tokenizeGraphQL(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("query is empty")
+	}
+
+	p := &graphQLParser{tokens: tokens}
+	doc := &graphQLDocument{}
+	for !p.done() {
+		op, err := p.parseDefinition()
+		if err != nil {
+			return nil, err
+		}
+		doc.operations = append(doc.operations, op)
+	}
+	return doc, nil
+}
+
+// tokenizeGraphQL splits a query into names, punctuators and literal values, dropping comments
+func tokenizeGraphQL(query string) ([]string, error) {
+	var tokens []string
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c) || c == ',' || c == '\uFEFF':
+			i++
+		case c == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			start := i
+			if strings.HasPrefix(string(runes[i:]), `"""`) {
+				end := strings.Index(string(runes[i+3:]), `"""`)
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated block string")
+				}
+				i += 3 + len([]rune(string(runes[i+3:])[:end])) + 3
+			} else {
+				i++
+				for i < len(runes) && runes[i] != '"' {
+					if runes[i] == '\\' {
+						i++
+					}
+					if i < len(runes) && runes[i] == '\n' {
+						return nil, fmt.Errorf("unterminated string")
+					}
+					i++
+				}
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string")
+				}
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case c == '.':
+			if !strings.HasPrefix(string(runes[i:]), "...") {
+				return nil, fmt.Errorf("unexpected character '.'")
+			}
+			tokens = append(tokens, "...")
+			i += 3
+		case strings.ContainsRune("{}()[]:=@$!|&", c):
+			tokens = append(tokens, string(c))
+			i++
+		case c == '_' || c == '-' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || runes[i] == '-' || runes[i] == '.' && unicode.IsDigit(runes[i-1]) ||
+				runes[i] == '+' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// done reports whether all tokens were consumed
+func (p *graphQLParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// peek returns the current token or "" at the end
+func (p *graphQLParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the current token
+func (p *graphQLParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+// expect consumes tok or fails
+func (p *graphQLParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		if got == "" {
+			return fmt.Errorf("expected %q but the query ended", tok)
+		}
+		return fmt.Errorf("expected %q but found %q", tok, got)
+	}
+	return nil
+}
+
+// skipBalanced skips from an opening bracket to its matching closing bracket
+func (p *graphQLParser) skipBalanced(open, close string) error {
+	depth := 0
+	for !p.done() {
+		switch p.next() {
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("unbalanced %q", open)
+}
+
+// skipDirectives skips any @directive(args) that follow
+func (p *graphQLParser) skipDirectives() error {
+	for p.peek() == "@" {
+		p.next()
+		p.next()
+		if p.peek() == "(" {
+			if err := p.skipBalanced("(", ")"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseDefinition parses an operation or fragment definition
+func (p *graphQLParser) parseDefinition() (graphQLOperation, error) {
+	op := graphQLOperation{kind: "query"}
+
+	switch tok := p.peek(); tok {
+	case "{":
+		// Anonymous query shorthand
+	case "query", "mutation", "subscription":
+		op.kind = p.next()
+		if p.peek() != "(" && p.peek() != "{" && p.peek() != "@" {
+			p.next() // operation name
+		}
+		if p.peek() == "(" {
+			if err := p.skipBalanced("(", ")"); err != nil {
+				return op, err
+			}
+		}
+	case "fragment":
+		op.kind = p.next()
+		p.next() // fragment name
+		if err := p.expect("on"); err != nil {
+			return op, err
+		}
+		op.typeCondition = p.next()
+	default:
+		return op, fmt.Errorf("unexpected %q, expected an operation or fragment", tok)
+	}
+
+	if err := p.skipDirectives(); err != nil {
+		return op, err
+	}
+
+	selections, err := p.parseSelectionSet()
+	if err != nil {
+		return op, err
+	}
+	op.selections = selections
+	return op, nil
+}
+
+// parseSelectionSet parses { selection... }
+func (p *graphQLParser) parseSelectionSet() ([]graphQLSelection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var selections []graphQLSelection
+	for p.peek() != "}" {
+		if p.done() {
+			return nil, fmt.Errorf("unbalanced \"{\"")
+		}
+
+		var sel graphQLSelection
+		if p.peek() == "..." {
+			p.next()
+			switch p.peek() {
+			case "on":
+				p.next()
+				sel.inlineType = p.next()
+			case "{", "@":
+			default:
+				sel.fragmentSpread = p.next()
+			}
+		} else {
+			name := p.next()
+			if !isGraphQLName(name) {
+				return nil, fmt.Errorf("unexpected %q in selection set", name)
+			}
+			if p.peek() == ":" {
+				// Alias: the field name follows
+				p.next()
+				name = p.next()
+			}
+			sel.field = name
+			if p.peek() == "(" {
+				if err := p.skipBalanced("(", ")"); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if err := p.skipDirectives(); err != nil {
+			return nil, err
+		}
+		if p.peek() == "{" {
+			children, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			sel.children = children
+		}
+		selections = append(selections, sel)
+	}
+	p.next()
+
+	if len(selections) == 0 {
+		return nil, fmt.Errorf("selection set cannot be empty")
+	}
+	return selections, nil
+}
+
+// isGraphQLName reports whether tok is a valid GraphQL name
+func isGraphQLName(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	for i, c := range tok {
+		if c == '_' || ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || (i > 0 && '0' <= c && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
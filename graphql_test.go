@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGraphQLSchemaStoreRejectsPaths(t *testing.T) {
+	store := &graphQLSchemaStore{dir: t.TempDir(), schemas: make(map[string]*GraphQLSchema)}
+
+	for _, id := range []string{"../settings", "..", "a/b", `a\b`, "C:evil", "/etc/passwd"} {
+		if err := store.put(id, &GraphQLSchema{}); err == nil {
+			t.Errorf("put(%q) succeeded, want an invalid collection ID error", id)
+		}
+		if _, err := store.get(id); err == nil {
+			t.Errorf("get(%q) succeeded, want an invalid collection ID error", id)
+		}
+	}
+
+	if err := store.put("col_123", &GraphQLSchema{}); err != nil {
+		t.Fatal(err)
+	}
+	if schema, err := store.get("col_123"); err != nil || schema == nil {
+		t.Errorf("get(col_123) = %v, %v, want the stored schema", schema, err)
+	}
+}
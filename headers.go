@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// Header is a single header line; disabled headers are kept but not sent
+type Header struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Headers is an ordered list of headers in which a name may repeat
+type Headers []Header
+
+// UnmarshalJSON accepts the list form as well as the older {"Name": "value"} object,
+// keeping the object's key order. Rows without an enabled flag are treated as enabled.
+func (h *Headers) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*h = nil
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '{' {
+		return h.unmarshalLegacy(data)
+	}
+
+	var rows []struct {
+		Key     string `json:"key"`
+		Value   string `json:"value"`
+		Enabled *bool  `json:"enabled"`
+	}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return err
+	}
+
+	headers := make(Headers, len(rows))
+	for i, row := range rows {
+		headers[i] = Header{Key: row.Key, Value: row.Value, Enabled: row.Enabled == nil || *row.Enabled}
+	}
+	*h = headers
+	return nil
+}
+
+// unmarshalLegacy reads the map form used before headers were ordered
+func (h *Headers) unmarshalLegacy(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	headers := Headers{}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return fmt.Errorf("invalid header name %v", token)
+		}
+		var value string
+		if err := dec.Decode(&value); err != nil {
+			return fmt.Errorf("invalid value for header %s: %w", key, err)
+		}
+		headers = append(headers, Header{Key: key, Value: value, Enabled: true})
+	}
+	*h = headers
+	return nil
+}
+
+// Get returns the first enabled value for name, matched case-insensitively
+func (h Headers) Get(name string) string {
+	for _, header := range h {
+		if header.Enabled && strings.EqualFold(header.Key, name) {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+// Has reports whether an enabled header with the given name exists
+func (h Headers) Has(name string) bool {
+	for _, header := range h {
+		if header.Enabled && strings.EqualFold(header.Key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// headersFromMap converts a name/value map into enabled headers sorted by name
+func headersFromMap(m map[string]string) Headers {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := make(Headers, 0, len(names))
+	for _, name := range names {
+		headers = append(headers, Header{Key: name, Value: m[name], Enabled: true})
+	}
+	return headers
+}
+
+// headersFromHTTP converts a received http.Header. net/http does not keep the order headers
+// arrived in, so names are listed alphabetically; the values of a repeated header keep
+// their received order.
+func headersFromHTTP(header http.Header) Headers {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := make(Headers, 0, len(header))
+	for _, name := range names {
+		for _, value := range header[name] {
+			headers = append(headers, Header{Key: name, Value: value, Enabled: true})
+		}
+	}
+	return headers
+}
+
+// parseHeaderBlock reads the header lines of a dumped HTTP message in the order they appear
+func parseHeaderBlock(dump []byte) Headers {
+	headers := Headers{}
+	lines := strings.Split(string(dump), "\r\n")
+	// The first line is the request or status line
+	for _, line := range lines[1:] {
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		headers = append(headers, Header{
+			Key:     textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name)),
+			Value:   strings.TrimSpace(value),
+			Enabled: true,
+		})
+	}
+	return headers
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthTypeHMAC signs requests with an HMAC over a template of request parts
+const AuthTypeHMAC = "hmac"
+
+// defaultHMACTemplate is the string signed when no template is configured
+const defaultHMACTemplate = "{{method}}\n{{path}}\n{{timestamp}}\n{{body}}"
+
+// HMACAuth signs a string built from request parts and sends the signature in a header.
+// The template may reference {{method}}, {{path}}, {{query}}, {{url}}, {{host}},
+// {{timestamp}}, {{nonce}}, {{body}}, {{bodySha256}} and {{header:Name}}; it is not
+// substituted with variables, unlike the other fields.
+type HMACAuth struct {
+	Secret          string `json:"secret"`
+	Algorithm       string `json:"algorithm,omitempty"` // sha256 (default), sha1 or sha512
+	Template        string `json:"template,omitempty"`
+	Encoding        string `json:"encoding,omitempty"` // hex (default) or base64
+	Header          string `json:"header,omitempty"`   // defaults to X-Signature
+	Prefix          string `json:"prefix,omitempty"`   // prepended to the signature in the header
+	TimestampHeader string `json:"timestampHeader,omitempty"`
+	TimestampFormat string `json:"timestampFormat,omitempty"` // unix (default), unixms or rfc3339
+	NonceHeader     string `json:"nonceHeader,omitempty"`
+}
+
+// expandHMAC substitutes variables in the HMAC configuration, leaving the template untouched
+func expandHMAC(cfg *HMACAuth, r *variableResolver) *HMACAuth {
+	expanded := *cfg
+	expanded.Secret = r.expand(cfg.Secret)
+	expanded.Prefix = r.expand(cfg.Prefix)
+	return &expanded
+}
+
+// signHMACRequest computes the signature over the final request and sets the configured headers
+func signHMACRequest(httpReq *http.Request, cfg *HMACAuth, now time.Time) error {
+	if cfg.Secret == "" {
+		return fmt.Errorf("HMAC auth requires a secret")
+	}
+
+	var newHash func() hash.Hash
+	switch strings.ToLower(cfg.Algorithm) {
+	case "", "sha256":
+		newHash = sha256.New
+	case "sha1":
+		newHash = sha1.New
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return fmt.Errorf("unsupported HMAC algorithm %q", cfg.Algorithm)
+	}
+
+	body, err := readReplayableBody(httpReq)
+	if err != nil {
+		return err
+	}
+
+	var timestamp string
+	switch strings.ToLower(cfg.TimestampFormat) {
+	case "", "unix":
+		timestamp = strconv.FormatInt(now.Unix(), 10)
+	case "unixms":
+		timestamp = strconv.FormatInt(now.UnixMilli(), 10)
+	case "rfc3339":
+		timestamp = now.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Errorf("unsupported timestamp format %q", cfg.TimestampFormat)
+	}
+	nonce := randomURLSafeString(16)
+
+	template := cfg.Template
+	if template == "" {
+		template = defaultHMACTemplate
+	}
+
+	// Send the signed timestamp and nonce so the server can rebuild the string
+	usesPart := func(name string) bool {
+		for _, m := range variablePattern.FindAllStringSubmatch(template, -1) {
+			if m[1] == name {
+				return true
+			}
+		}
+		return false
+	}
+	if usesPart("timestamp") || cfg.TimestampHeader != "" {
+		timestampHeader := cfg.TimestampHeader
+		if timestampHeader == "" {
+			timestampHeader = "X-Timestamp"
+		}
+		httpReq.Header.Set(timestampHeader, timestamp)
+	}
+	if usesPart("nonce") || cfg.NonceHeader != "" {
+		nonceHeader := cfg.NonceHeader
+		if nonceHeader == "" {
+			nonceHeader = "X-Nonce"
+		}
+		httpReq.Header.Set(nonceHeader, nonce)
+	}
+
+	var templateErr error
+	message := variablePattern.ReplaceAllStringFunc(template, func(match string) string {
+		part := variablePattern.FindStringSubmatch(match)[1]
+		switch {
+		case part == "method":
+			return httpReq.Method
+		case part == "path":
+			return httpReq.URL.EscapedPath()
+		case part == "query":
+			return httpReq.URL.RawQuery
+		case part == "url":
+			return httpReq.URL.String()
+		case part == "host":
+			return httpReq.URL.Host
+		case part == "timestamp":
+			return timestamp
+		case part == "nonce":
+			return nonce
+		case part == "body":
+			return string(body)
+		case part == "bodySha256":
+			return sha256Hex(body)
+		case strings.HasPrefix(part, "header:"):
+			return httpReq.Header.Get(strings.TrimPrefix(part, "header:"))
+		}
+		templateErr = fmt.Errorf("unknown HMAC template part %q", part)
+		return match
+	})
+	if templateErr != nil {
+		return templateErr
+	}
+
+	mac := hmac.New(newHash, []byte(cfg.Secret))
+	mac.Write([]byte(message))
+	sum := mac.Sum(nil)
+
+	var signature string
+	switch strings.ToLower(cfg.Encoding) {
+	case "", "hex":
+		signature = hex.EncodeToString(sum)
+	case "base64":
+		signature = base64.StdEncoding.EncodeToString(sum)
+	default:
+		return fmt.Errorf("unsupported signature encoding %q", cfg.Encoding)
+	}
+
+	header := cfg.Header
+	if header == "" {
+		header = "X-Signature"
+	}
+	httpReq.Header.Set(header, cfg.Prefix+signature)
+
+	return nil
+}
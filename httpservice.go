@@ -1,72 +1,305 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/textproto"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // HTTPService handles HTTP requests for the Postman-like client
 type HTTPService struct {
-	client            *http.Client
 	logService        *LogService
 	collectionService *CollectionService
+	oauth2            *oauth2Manager
+	graphqlSchemas    *graphQLSchemaStore
+	transports        *transportPool
+	certificates      *certificateStore
+	settingsService   *SettingsService
+	cookies           *cookieStore
+	inFlight          *inFlightRegistry
+	responseBodies    *responseBodyStore
+	// events forwards stream events to the frontend; it is attached in main
+	events *EventBusService
 }
 
 // NewHTTPService creates a new HTTP service
 func NewHTTPService() *HTTPService {
+	collectionService := NewCollectionService()
 	return &HTTPService{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
 		logService:        NewLogService(),
-		collectionService: NewCollectionService(),
+		collectionService: collectionService,
+		oauth2:            newOAuth2Manager(),
+		graphqlSchemas:    newGraphQLSchemaStore(),
+		transports:        newTransportPool(),
+		certificates:      newCertificateStore(collectionService.secrets),
+		settingsService:   NewSettingsService(),
+		cookies:           newCookieStore(),
+		inFlight:          newInFlightRegistry(),
+		responseBodies:    newResponseBodyStore(),
 	}
 }
 
 // NewHTTPServiceWithCollection creates a new HTTP service with a shared collection service
 func NewHTTPServiceWithCollection(collectionService *CollectionService) *HTTPService {
 	return &HTTPService{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
 		logService:        NewLogService(),
 		collectionService: collectionService,
+		oauth2:            newOAuth2Manager(),
+		graphqlSchemas:    newGraphQLSchemaStore(),
+		transports:        newTransportPool(),
+		certificates:      newCertificateStore(collectionService.secrets),
+		settingsService:   NewSettingsService(),
+		cookies:           newCookieStore(),
+		inFlight:          newInFlightRegistry(),
+		responseBodies:    newResponseBodyStore(),
 	}
 }
 
 // HTTPRequest represents an HTTP request structure
 type HTTPRequest struct {
-	Method       string            `json:"method"`
-	URL          string            `json:"url"`
-	Headers      map[string]string `json:"headers"`
-	Body         string            `json:"body"`
-	CollectionID string            `json:"collectionId,omitempty"`
+	// RequestID identifies the send for CancelRequest; one is generated when empty
+	RequestID    string           `json:"requestId,omitempty"`
+	Method       string           `json:"method"`
+	URL          string           `json:"url"`
+	Params       []QueryParam     `json:"params,omitempty"`
+	PathParams   []PathParam      `json:"pathParams,omitempty"`
+	Headers      Headers          `json:"headers"`
+	Body         string           `json:"body"`
+	BodyMode     string           `json:"bodyMode,omitempty"`
+	URLEncoded   []KeyValue       `json:"urlencoded,omitempty"`
+	FormData     []FormField      `json:"formData,omitempty"`
+	BinaryFile   string           `json:"binaryFile,omitempty"`
+	GraphQL      *GraphQLBody     `json:"graphql,omitempty"`
+	Compression  string           `json:"compression,omitempty"` // gzip, deflate, br or zstd
+	Variables    []Variable       `json:"variables,omitempty"`
+	Auth         *RequestAuth     `json:"auth,omitempty"`
+	Settings     *RequestSettings `json:"settings,omitempty"`
+	CollectionID string           `json:"collectionId,omitempty"`
 }
 
 // HTTPResponse represents an HTTP response structure
 type HTTPResponse struct {
-	StatusCode     int               `json:"statusCode"`
-	Status         string            `json:"status"`
-	Headers        map[string]string `json:"headers"`
-	RequestHeaders map[string]string `json:"requestHeaders"`
-	Body           string            `json:"body"`
-	Duration       int64             `json:"duration"` // in milliseconds
-	Size           int64             `json:"size"`     // response size in bytes
+	RequestID  string `json:"requestId"`
+	StatusCode int    `json:"statusCode"`
+	Status     string `json:"status"`
+	// Headers are sorted by name since the order they were received in is not available;
+	// repeated headers keep every value in received order
+	Headers        Headers `json:"headers"`
+	RequestHeaders Headers `json:"requestHeaders"`
+	Body           string  `json:"body"`
+	// BodyEncoding is "base64" when the body is binary
+	BodyEncoding string `json:"bodyEncoding,omitempty"`
+	// MimeType is the body's media type, from Content-Type or sniffed from the content
+	MimeType string `json:"mimeType,omitempty"`
+	// Charset is the character set the body was decoded from
+	Charset string `json:"charset,omitempty"`
+	// Truncated is set when Body is only a preview; SaveResponseBody writes the full decoded body
+	Truncated bool  `json:"truncated,omitempty"`
+	Duration  int64 `json:"duration"` // in milliseconds
+	Size      int64 `json:"size"`     // decoded response size in bytes
+	// WireSize is the body size as received, before any Content-Encoding was decoded
+	WireSize int64 `json:"wireSize"`
+	// RawRequest and RawResponse are the exchange in HTTP/1.1 wire format
+	RawRequest  string `json:"rawRequest,omitempty"`
+	RawResponse string `json:"rawResponse,omitempty"`
+	// Timing breaks the send down into DNS, connect, TLS, first byte and transfer
+	Timing *Timing `json:"timing,omitempty"`
+	// TLS describes the connection and certificate chain for HTTPS responses
+	TLS *TLSInfo `json:"tls,omitempty"`
+	// Redirects lists the redirect responses followed before this one, in order
+	Redirects []RedirectHop `json:"redirects,omitempty"`
+	// RedirectLimitReached is set when MaxRedirects stopped the chain; the response is the
+	// redirect that was not followed, which is also the last entry in Redirects
+	RedirectLimitReached bool `json:"redirectLimitReached,omitempty"`
+	// GeneratedVariables holds the values produced by {{$dynamic}} placeholders for this send
+	GeneratedVariables []GeneratedVariable `json:"generatedVariables,omitempty"`
 }
 
 // SendRequest sends an HTTP request and returns the response
 func (h *HTTPService) SendRequest(ctx context.Context, req HTTPRequest) (*HTTPResponse, error) {
 	start := time.Now()
 
+	// Register the request so it can be cancelled while it runs
+	if req.RequestID == "" {
+		req.RequestID = uuid.New().String()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	err := h.inFlight.add(InFlightRequest{
+		ID:           req.RequestID,
+		Method:       req.Method,
+		URL:          req.URL,
+		CollectionID: req.CollectionID,
+		StartedAt:    start,
+	}, cancel)
+	if err != nil {
+		return nil, err
+	}
+	defer h.inFlight.remove(req.RequestID)
+
+	// Resolve variables, collection settings, body and auth into the HTTP request
+	prepared, err := h.prepareRequest(ctx, req)
+	if err != nil {
+		// Auth can block on the user, e.g. the OAuth2 browser login, so a cancel may land here.
+		// Variables are not expanded yet, so there are no resolved secrets to mask.
+		if h.inFlight.cancelled(req.RequestID) {
+			return nil, h.requestCancelled(ctx, req, start, nil)
+		}
+		return nil, err
+	}
+	req, httpReq, body, settings := prepared.req, prepared.httpReq, prepared.body, prepared.settings
+	auth, tokenScope, resolver := prepared.auth, prepared.tokenScope, prepared.resolver
+
+	// Send request, answering an auth challenge if needed
+	redirects := newRedirectRecorder()
+	client, err := h.clientFor(settings, httpReq.URL, redirects, h.cookies.jar(tokenScope))
+	if err != nil {
+		if httpReq.Body != nil {
+			httpReq.Body.Close()
+		}
+		return nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+	tracer := newRequestTracer()
+	httpReq = httpReq.WithContext(httptrace.WithClientTrace(httpReq.Context(), tracer.clientTrace()))
+	resp, httpReq, err := h.doWithAuth(client, httpReq, auth, tokenScope, settings, redirects)
+	if err != nil {
+		if h.inFlight.cancelled(req.RequestID) {
+			return nil, h.requestCancelled(ctx, req, start, resolver.secrets)
+		}
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read response body, decoding its Content-Encoding and spooling large bodies to disk
+	wire := &countingReader{r: resp.Body}
+	var reader io.ReadCloser = io.NopCloser(wire)
+	decoded := resp.Header.Get("Content-Encoding") == ""
+	if !settings.disableDecompression {
+		reader, decoded = decodeContent(wire, resp.Header.Get("Content-Encoding"))
+	}
+	defer reader.Close()
+	respBody, err := h.responseBodies.read(req.RequestID, reader, resp.Header.Get("Content-Type"), !decoded)
+	if err != nil {
+		if h.inFlight.cancelled(req.RequestID) {
+			return nil, h.requestCancelled(ctx, req, start, resolver.secrets)
+		}
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// Calculate duration
+	end := time.Now()
+	duration := end.Sub(start).Milliseconds()
+	timing := tracer.timing(end)
+
+	// Capture the final request headers, including those added by the client
+	// by dumping the request and reading the header lines back in order.
+	// We dump without the body to avoid consuming the body reader.
+	// The dump runs its own round trip, so it must not report to the tracer.
+	dump, err := httputil.DumpRequestOut(httpReq.WithContext(ctx), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump request for header capture: %w", err)
+	}
+	reqHeaders := parseHeaderBlock(dump)
+	bodyText, bodyEncoding := respBody.text()
+
+	// Render the exchange in wire format for copying into bug reports
+	rawReq, err := rawRequest(dump, body, httpReq.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture raw request: %w", err)
+	}
+	rawResp, err := rawResponse(resp, respBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture raw response: %w", err)
+	}
+
+	// Create response object
+	response := &HTTPResponse{
+		RequestID:      req.RequestID,
+		StatusCode:     resp.StatusCode,
+		Status:         resp.Status,
+		Headers:        headersFromHTTP(resp.Header),
+		RequestHeaders: reqHeaders,
+		Body:           bodyText,
+		BodyEncoding:   bodyEncoding,
+		MimeType:       respBody.mimeType,
+		Charset:        respBody.charset,
+		Truncated:      respBody.truncated,
+		Duration:       duration,
+		Size:           respBody.size,
+		WireSize:       wire.n,
+		RawRequest:     rawReq,
+		RawResponse:    rawResp,
+		Timing:         timing,
+		TLS:            newTLSInfo(resp.TLS, resp.Request.URL.Hostname(), transportRoots(client, resp.Request.URL), end),
+		Redirects:      redirects.hops,
+
+		RedirectLimitReached: redirects.limitReached,
+		GeneratedVariables:   resolver.generated,
+	}
+
+	// Log the request and response, keeping the raw exchange only when enabled
+	if h.logService != nil {
+		logged := *response
+		params := credentialQueryParams(auth)
+		logged.Redirects = redactRedirectParams(logged.Redirects, params)
+		if app, err := h.settingsService.load(); err != nil || !app.LogRawExchange {
+			logged.RawRequest, logged.RawResponse = "", ""
+		} else {
+			logged.RawRequest = redactRawHeaders(redactRequestLine(logged.RawRequest, params), credentialHeaders(auth))
+		}
+		_ = h.logService.LogRequest(ctx, req, &logged, duration, resolver.secrets)
+	}
+
+	return response, nil
+}
+
+// preparedRequest is a request ready to be sent, with variables, collection settings and auth applied
+type preparedRequest struct {
+	// req has its variables expanded and its body replaced by the log summary
+	req        HTTPRequest
+	httpReq    *http.Request
+	body       *requestBody
+	auth       *RequestAuth
+	tokenScope string
+	settings   sendSettings
+	resolver   *variableResolver
+}
+
+// prepareRequest resolves variables, parameters, collection headers, settings, body and auth
+// and builds the HTTP request bound to ctx
+func (h *HTTPService) prepareRequest(ctx context.Context, req HTTPRequest) (*preparedRequest, error) {
+	// Substitute {{variable}} placeholders from every variable scope
+	variables, err := h.collectVariables(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve variables: %w", err)
+	}
+	resolver := newVariableResolver(resolvedVariablesToMap(variables))
+	resolver.secrets = secretValues(variables)
+	req.URL = resolver.expand(req.URL)
+
+	// Fill in path parameters and append the enabled query parameters
+	params := make([]QueryParam, len(req.Params))
+	for i, p := range req.Params {
+		params[i] = p
+		if p.Enabled {
+			params[i].Key = resolver.expand(p.Key)
+			params[i].Value = resolver.expand(p.Value)
+		}
+	}
+	pathParams := make([]PathParam, len(req.PathParams))
+	for i, p := range req.PathParams {
+		pathParams[i] = PathParam{Key: p.Key, Value: resolver.expand(p.Value), Description: p.Description}
+	}
+	req.URL = buildRequestURL(req.URL, params, pathParams)
+
 	// Resolve URL with collection environment base URL if needed
 	resolvedURL, err := h.resolveURL(ctx, req.URL, req.CollectionID)
 	if err != nil {
@@ -83,102 +316,118 @@ func (h *HTTPService) SendRequest(ctx context.Context, req HTTPRequest) (*HTTPRe
 		}
 	}
 
-	// Validate and clean JSON body if content-type is JSON
-	if req.Body != "" {
-		contentType := req.Headers["Content-Type"]
-		if contentType == "" {
-			contentType = req.Headers["content-type"]
+	// Substitute variables in header values and body
+	expandedHeaders := make(Headers, len(req.Headers))
+	for i, header := range req.Headers {
+		expandedHeaders[i] = header
+		if header.Enabled {
+			expandedHeaders[i].Key = resolver.expand(header.Key)
+			expandedHeaders[i].Value = resolver.expand(header.Value)
 		}
+	}
+	req.Headers = expandedHeaders
+	req.Body = resolver.expand(req.Body)
+	expandBodyFields(&req, resolver)
+	effective, err := h.effectiveAuth(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt auth: %w", err)
+	}
+	auth := expandAuth(effective, resolver)
 
-		if strings.Contains(strings.ToLower(contentType), "application/json") {
-			// Validate JSON format
-			var jsonTest interface{}
-			if err := json.Unmarshal([]byte(req.Body), &jsonTest); err != nil {
-				return nil, fmt.Errorf("invalid JSON body: %w", err)
-			}
-			// Re-marshal to ensure clean JSON
-			cleanJSON, err := json.Marshal(jsonTest)
+	// Refuse to send a request that still contains placeholders
+	if err := resolver.err(); err != nil {
+		return nil, err
+	}
+
+	// Validate and clean JSON body if content-type is JSON
+	if req.Body != "" && (req.BodyMode == "" || req.BodyMode == BodyModeRaw || req.BodyMode == BodyModeJSON) {
+		contentType := req.Headers.Get("Content-Type")
+		if req.BodyMode == BodyModeJSON || strings.Contains(strings.ToLower(contentType), "application/json") {
+			req.Body, err = cleanJSONBody(req.Body)
 			if err != nil {
-				return nil, fmt.Errorf("failed to clean JSON: %w", err)
+				return nil, err
 			}
-			req.Body = string(cleanJSON)
 		}
 	}
 
-	// Create HTTP request
-	var bodyReader io.Reader
-	if req.Body != "" {
-		bodyReader = strings.NewReader(req.Body)
+	// Check GraphQL queries against the collection's schema, if one has been introspected
+	if req.BodyMode == BodyModeGraphQL && req.GraphQL != nil {
+		if schema, _ := h.graphqlSchemas.get(req.CollectionID); schema != nil {
+			if problems := validateGraphQLQuery(req.GraphQL.Query, schema); len(problems) > 0 {
+				return nil, fmt.Errorf("invalid GraphQL query: %s", strings.Join(problems, "; "))
+			}
+		}
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, req.Method, req.URL, bodyReader)
+	// Resolve this request's timeout, redirect and TLS settings
+	settings, err := h.requestSettings(ctx, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Set headers
-	for key, value := range req.Headers {
-		httpReq.Header.Set(key, value)
+		return nil, err
 	}
 
-	// Send request
-	resp, err := h.client.Do(httpReq)
+	// Build the body for the selected body mode
+	body, err := buildRequestBody(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to build request body: %w", err)
+	}
+	req.Body = body.summary
+	if req.Compression != "" {
+		if err := body.compress(req.Compression); err != nil {
+			return nil, fmt.Errorf("failed to compress request body: %w", err)
+		}
 	}
-	defer resp.Body.Close()
 
-	// Read response body
-	bodyBytes, err := io.ReadAll(resp.Body)
+	// Create HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, req.URL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-
-	// Calculate duration
-	duration := time.Since(start).Milliseconds()
-
-	// Convert headers to map
-	headers := make(map[string]string)
-	for key, values := range resp.Header {
-		headers[key] = strings.Join(values, ", ")
+	if err := checkHTTPVersion(settings, httpReq.URL); err != nil {
+		return nil, err
 	}
 
-	// Capture the final request headers, including those added by the client
-	// by dumping the request and parsing the headers back.
-	// We dump without the body to avoid consuming the body reader.
-	dump, err := httputil.DumpRequestOut(httpReq, false)
-	if err != nil {
-		return nil, fmt.Errorf("failed to dump request for header capture: %w", err)
+	// Set headers, keeping repeated names as separate values
+	for _, header := range req.Headers {
+		if header.Enabled && header.Key != "" {
+			httpReq.Header.Add(header.Key, header.Value)
+		}
 	}
 
-	reader := bufio.NewReader(bytes.NewReader(dump))
-	parsedReq, err := http.ReadRequest(reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse dumped request: %w", err)
+	if err := body.attach(httpReq); err != nil {
+		return nil, fmt.Errorf("failed to attach request body: %w", err)
 	}
 
-	reqHeaders := make(map[string]string)
-	for key, values := range parsedReq.Header {
-		reqHeaders[key] = strings.Join(values, ", ")
+	// Ask for compressed responses unless the request chose its own encodings
+	if !settings.disableDecompression && httpReq.Header.Get("Accept-Encoding") == "" {
+		httpReq.Header.Set("Accept-Encoding", acceptEncoding)
 	}
 
-	// Create response object
-	response := &HTTPResponse{
-		StatusCode:     resp.StatusCode,
-		Status:         resp.Status,
-		Headers:        headers,
-		RequestHeaders: reqHeaders,
-		Body:           string(bodyBytes),
-		Duration:       duration,
-		Size:           int64(len(bodyBytes)),
+	// Apply authentication
+	tokenScope := h.tokenScope(ctx, req.CollectionID)
+	if err := h.applyAuth(httpReq, auth, tokenScope, settings); err != nil {
+		if httpReq.Body != nil {
+			httpReq.Body.Close()
+		}
+		return nil, fmt.Errorf("failed to apply auth: %w", err)
 	}
 
-	// Log the request and response
+	return &preparedRequest{
+		req:        req,
+		httpReq:    httpReq,
+		body:       body,
+		auth:       auth,
+		tokenScope: tokenScope,
+		settings:   settings,
+		resolver:   resolver,
+	}, nil
+}
+
+// requestCancelled logs a request aborted through CancelRequest and returns the error reported for it
+func (h *HTTPService) requestCancelled(ctx context.Context, req HTTPRequest, start time.Time, secrets []string) error {
 	if h.logService != nil {
-		_ = h.logService.LogRequest(ctx, req, response, duration)
+		_ = h.logService.logCancelled(req, time.Since(start).Milliseconds(), secrets)
 	}
-
-	return response, nil
+	return fmt.Errorf("request %s was cancelled", req.RequestID)
 }
 
 // ValidateURL checks if a URL is valid
@@ -269,31 +518,31 @@ func (h *HTTPService) ExportRequestLogsAsJSON(ctx context.Context) (string, erro
 	return h.logService.ExportLogsAsJSON(ctx)
 }
 
-// mergeCollectionHeaders returns request headers as-is since environment headers are now managed separately
-func (h *HTTPService) mergeCollectionHeaders(ctx context.Context, collectionID string, requestHeaders map[string]string) (map[string]string, error) {
-	// Start with a new map to avoid mutating the original
-	merged := make(map[string]string)
+// mergeCollectionHeaders puts the active header collection in front of the request headers
+func (h *HTTPService) mergeCollectionHeaders(ctx context.Context, collectionID string, requestHeaders Headers) (Headers, error) {
+	// Start with a new list to avoid mutating the original
+	merged := Headers{}
 
-	// Bring in active header collection (if any)
+	// Bring in active header collection (if any), skipping names the request sets itself
 	if h.collectionService != nil {
 		hc, err := h.collectionService.GetActiveHeaderCollection(ctx, collectionID)
 		if err != nil {
 			// Surface error to caller to optionally warn but not fail request
-			return nil, err
+			return requestHeaders, err
 		}
 		if hc != nil && hc.Headers != nil {
-			for k, v := range hc.Headers {
-				ck := textproto.CanonicalMIMEHeaderKey(k)
-				merged[ck] = v
+			for _, header := range headersFromMap(hc.Headers) {
+				if requestHeaders.Has(header.Key) {
+					continue
+				}
+				header.Key = textproto.CanonicalMIMEHeaderKey(header.Key)
+				merged = append(merged, header)
 			}
 		}
 	}
 
-	// Overlay request-specific headers (take precedence)
-	for k, v := range requestHeaders {
-		ck := textproto.CanonicalMIMEHeaderKey(k)
-		merged[ck] = v
-	}
+	// Request-specific headers follow in their own order
+	merged = append(merged, requestHeaders...)
 
 	return merged, nil
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// InFlightRequest describes a request that is still being sent
+type InFlightRequest struct {
+	ID           string    `json:"id"`
+	Method       string    `json:"method"`
+	URL          string    `json:"url"`
+	CollectionID string    `json:"collectionId,omitempty"`
+	StartedAt    time.Time `json:"startedAt"`
+}
+
+// inFlightEntry is a registered request with the function that aborts it
+type inFlightEntry struct {
+	info      InFlightRequest
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// inFlightRegistry tracks running requests so they can be cancelled
+type inFlightRegistry struct {
+	mu       sync.Mutex
+	requests map[string]*inFlightEntry
+}
+
+// newInFlightRegistry creates an empty registry
+func newInFlightRegistry() *inFlightRegistry {
+	return &inFlightRegistry{
+		requests: make(map[string]*inFlightEntry),
+	}
+}
+
+// add registers a request; it fails when the ID is already running
+func (r *inFlightRegistry) add(info InFlightRequest, cancel context.CancelFunc) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.requests[info.ID]; ok {
+		return fmt.Errorf("request %s is already in flight", info.ID)
+	}
+	r.requests[info.ID] = &inFlightEntry{info: info, cancel: cancel}
+	return nil
+}
+
+// remove unregisters a finished request
+func (r *inFlightRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.requests, id)
+}
+
+// cancelled reports whether the request was cancelled through the registry
+func (r *inFlightRegistry) cancelled(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.requests[id]
+	return ok && entry.cancelled
+}
+
+// cancel aborts a running request
+func (r *inFlightRegistry) cancel(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.requests[id]
+	if !ok {
+		return false
+	}
+	entry.cancelled = true
+	entry.cancel()
+	return true
+}
+
+// cancelAll aborts every running request and returns how many there were
+func (r *inFlightRegistry) cancelAll() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, entry := range r.requests {
+		entry.cancelled = true
+		entry.cancel()
+	}
+	return len(r.requests)
+}
+
+// list returns the running requests, oldest first
+func (r *inFlightRegistry) list() []InFlightRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	requests := make([]InFlightRequest, 0, len(r.requests))
+	for _, entry := range r.requests {
+		requests = append(requests, entry.info)
+	}
+	sort.Slice(requests, func(a, b int) bool {
+		return requests[a].StartedAt.Before(requests[b].StartedAt)
+	})
+	return requests
+}
+
+// CancelRequest aborts the in-flight request with the given ID
+func (h *HTTPService) CancelRequest(ctx context.Context, id string) error {
+	if !h.inFlight.cancel(id) {
+		return fmt.Errorf("request %s is not in flight", id)
+	}
+	return nil
+}
+
+// CancelAllRequests aborts every in-flight request and returns how many were cancelled
+func (h *HTTPService) CancelAllRequests(ctx context.Context) int {
+	return h.inFlight.cancelAll()
+}
+
+// ListInFlight returns the requests that are still running
+func (h *HTTPService) ListInFlight(ctx context.Context) []InFlightRequest {
+	return h.inFlight.list()
+}
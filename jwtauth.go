@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// AuthTypeJWT authenticates requests with a JWT minted at send time
+const AuthTypeJWT = "jwt"
+
+// defaultJWTLifetime is used when JWTAuth.ExpiresIn is not set
+const defaultJWTLifetime = 5 * time.Minute
+
+// JWTAuth mints a signed JWT from a claims template for every request.
+// iat and exp are added unless the claims already contain them.
+type JWTAuth struct {
+	Algorithm  string `json:"algorithm"`            // HS256, RS256 or ES256
+	Secret     string `json:"secret,omitempty"`     // HS256 key
+	PrivateKey string `json:"privateKey,omitempty"` // PEM key for RS256 and ES256
+	KeyID      string `json:"keyId,omitempty"`
+	Claims     string `json:"claims"`              // JSON object, variables are substituted
+	ExpiresIn  int    `json:"expiresIn,omitempty"` // lifetime in seconds
+	Header     string `json:"header,omitempty"`    // defaults to Authorization
+	Prefix     string `json:"prefix,omitempty"`    // defaults to "Bearer" for the Authorization header
+}
+
+// expandJWT substitutes variables in the JWT configuration
+func expandJWT(cfg *JWTAuth, r *variableResolver) *JWTAuth {
+	expanded := *cfg
+	expanded.Secret = r.expand(cfg.Secret)
+	expanded.PrivateKey = r.expand(cfg.PrivateKey)
+	expanded.KeyID = r.expand(cfg.KeyID)
+	expanded.Claims = r.expand(cfg.Claims)
+	return &expanded
+}
+
+// applyJWT mints a token and sets it on the request
+func applyJWT(httpReq *http.Request, cfg *JWTAuth, now time.Time) error {
+	token, err := mintJWT(cfg, now)
+	if err != nil {
+		return err
+	}
+
+	header := cfg.Header
+	prefix := cfg.Prefix
+	if header == "" {
+		header = "Authorization"
+		if prefix == "" {
+			prefix = "Bearer "
+		}
+	}
+	httpReq.Header.Set(header, prefix+token)
+	return nil
+}
+
+// mintJWT builds and signs a compact JWT
+func mintJWT(cfg *JWTAuth, now time.Time) (string, error) {
+	claims := map[string]any{}
+	if cfg.Claims != "" {
+		if err := json.Unmarshal([]byte(cfg.Claims), &claims); err != nil {
+			return "", fmt.Errorf("invalid JWT claims: %w", err)
+		}
+	}
+
+	lifetime := defaultJWTLifetime
+	if cfg.ExpiresIn > 0 {
+		lifetime = time.Duration(cfg.ExpiresIn) * time.Second
+	}
+	if _, ok := claims["iat"]; !ok {
+		claims["iat"] = now.Unix()
+	}
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = now.Add(lifetime).Unix()
+	}
+
+	header := map[string]any{"alg": cfg.Algorithm, "typ": "JWT"}
+	if cfg.KeyID != "" {
+		header["kid"] = cfg.KeyID
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch cfg.Algorithm {
+	case "HS256":
+		if cfg.Secret == "" {
+			return "", fmt.Errorf("HS256 requires a secret")
+		}
+		mac := hmac.New(sha256.New, []byte(cfg.Secret))
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case "RS256":
+		key, err := parsePrivateKeyPEM(cfg.PrivateKey)
+		if err != nil {
+			return "", err
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return "", fmt.Errorf("RS256 requires an RSA private key")
+		}
+		signature, err = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", fmt.Errorf("failed to sign JWT: %w", err)
+		}
+	case "ES256":
+		key, err := parsePrivateKeyPEM(cfg.PrivateKey)
+		if err != nil {
+			return "", err
+		}
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok || ecKey.Curve.Params().BitSize != 256 {
+			return "", fmt.Errorf("ES256 requires a P-256 private key")
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		if err != nil {
+			return "", fmt.Errorf("failed to sign JWT: %w", err)
+		}
+		// JWS uses the fixed-width r||s encoding rather than ASN.1
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	default:
+		return "", fmt.Errorf("unsupported JWT algorithm %q", cfg.Algorithm)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parsePrivateKeyPEM parses a PKCS#8, PKCS#1 or SEC 1 private key
+func parsePrivateKeyPEM(data string) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported private key format %q", block.Type)
+}
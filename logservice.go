@@ -18,25 +18,41 @@ type RequestLog struct {
 	Status    int            `json:"status"`
 	Timestamp time.Time      `json:"timestamp"`
 	Duration  int64          `json:"duration"` // in milliseconds
+	Timing    *Timing        `json:"timing,omitempty"`
 	Request   LoggedRequest  `json:"request"`
 	Response  LoggedResponse `json:"response"`
+	// RawRequest and RawResponse hold the wire-format exchange when raw logging is enabled
+	RawRequest  string `json:"rawRequest,omitempty"`
+	RawResponse string `json:"rawResponse,omitempty"`
+	// Cancelled is set when the request was aborted before a response was read
+	Cancelled bool `json:"cancelled,omitempty"`
+	// Redirects records every redirect hop followed before the final response
+	Redirects []RedirectHop `json:"redirects,omitempty"`
+	// RedirectLimitReached is set when the redirect chain was cut off by MaxRedirects
+	RedirectLimitReached bool `json:"redirectLimitReached,omitempty"`
+	// GeneratedVariables records dynamic values so the request can be reproduced
+	GeneratedVariables []GeneratedVariable `json:"generatedVariables,omitempty"`
 }
 
 // LoggedRequest represents the request part of a log entry
 type LoggedRequest struct {
-	Method  string            `json:"method"`
-	URL     string            `json:"url"`
-	Headers map[string]string `json:"headers"`
-	Body    string            `json:"body"`
+	Method  string  `json:"method"`
+	URL     string  `json:"url"`
+	Headers Headers `json:"headers"`
+	Body    string  `json:"body"`
 }
 
 // LoggedResponse represents the response part of a log entry
 type LoggedResponse struct {
-	StatusCode int               `json:"statusCode"`
-	Status     string            `json:"status"`
-	Headers    map[string]string `json:"headers"`
-	Body       string            `json:"body"`
-	Size       int64             `json:"size"`
+	StatusCode int     `json:"statusCode"`
+	Status     string  `json:"status"`
+	Headers    Headers `json:"headers"` // sorted by name, as for HTTPResponse.Headers
+	Body       string  `json:"body"`
+	// BodyEncoding is "base64" when the body is binary
+	BodyEncoding string `json:"bodyEncoding,omitempty"`
+	// Truncated is set when only a preview of the body was kept
+	Truncated bool  `json:"truncated,omitempty"`
+	Size      int64 `json:"size"`
 }
 
 // LogService manages request/response logs
@@ -68,8 +84,8 @@ func NewLogService() *LogService {
 	return service
 }
 
-// LogRequest adds a new request/response log entry
-func (l *LogService) LogRequest(ctx context.Context, req HTTPRequest, resp *HTTPResponse, duration int64) error {
+// LogRequest adds a new request/response log entry, masking any of the given secret values
+func (l *LogService) LogRequest(ctx context.Context, req HTTPRequest, resp *HTTPResponse, duration int64, secrets []string) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -79,25 +95,64 @@ func (l *LogService) LogRequest(ctx context.Context, req HTTPRequest, resp *HTTP
 	log := RequestLog{
 		ID:        id,
 		Method:    req.Method,
-		URL:       req.URL,
+		URL:       maskSecrets(req.URL, secrets),
 		Status:    resp.StatusCode,
 		Timestamp: time.Now(),
 		Duration:  duration,
+		Timing:    resp.Timing,
 		Request: LoggedRequest{
 			Method:  req.Method,
-			URL:     req.URL,
-			Headers: copyHeaders(req.Headers),
-			Body:    req.Body,
+			URL:     maskSecrets(req.URL, secrets),
+			Headers: maskHeaderSecrets(req.Headers, secrets),
+			Body:    maskSecrets(req.Body, secrets),
 		},
 		Response: LoggedResponse{
-			StatusCode: resp.StatusCode,
-			Status:     resp.Status,
-			Headers:    copyHeaders(resp.Headers),
-			Body:       resp.Body,
-			Size:       resp.Size,
+			StatusCode:   resp.StatusCode,
+			Status:       resp.Status,
+			Headers:      maskHeaderSecrets(resp.Headers, secrets),
+			Body:         maskSecrets(resp.Body, secrets),
+			BodyEncoding: resp.BodyEncoding,
+			Truncated:    resp.Truncated,
+			Size:         resp.Size,
 		},
+		RawRequest:           maskSecrets(resp.RawRequest, secrets),
+		RawResponse:          maskSecrets(resp.RawResponse, secrets),
+		Redirects:            maskRedirectSecrets(resp.Redirects, secrets),
+		RedirectLimitReached: resp.RedirectLimitReached,
+		GeneratedVariables:   maskGeneratedSecrets(resp.GeneratedVariables, secrets),
 	}
 
+	l.add(log)
+	return nil
+}
+
+// logCancelled records a request that was cancelled before its response was read
+func (l *LogService) logCancelled(req HTTPRequest, duration int64, secrets []string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.add(RequestLog{
+		ID:        time.Now().Format("20060102150405.000"),
+		Method:    req.Method,
+		URL:       maskSecrets(req.URL, secrets),
+		Timestamp: time.Now(),
+		Duration:  duration,
+		Cancelled: true,
+		Request: LoggedRequest{
+			Method:  req.Method,
+			URL:     maskSecrets(req.URL, secrets),
+			Headers: maskHeaderSecrets(req.Headers, secrets),
+			Body:    maskSecrets(req.Body, secrets),
+		},
+		Response: LoggedResponse{
+			Status: "Cancelled",
+		},
+	})
+	return nil
+}
+
+// add stores a log entry and saves the logs; the caller holds l.mu
+func (l *LogService) add(log RequestLog) {
 	// Add to the beginning of the slice (most recent first)
 	l.logs = append([]RequestLog{log}, l.logs...)
 
@@ -108,8 +163,6 @@ func (l *LogService) LogRequest(ctx context.Context, req HTTPRequest, resp *HTTP
 
 	// Save logs to disk
 	l.saveLogsToDisk()
-
-	return nil
 }
 
 // GetAllLogs returns all logged requests
@@ -172,17 +225,13 @@ func (l *LogService) ExportLogsAsJSON(ctx context.Context) (string, error) {
 	return string(jsonData), nil
 }
 
-// copyHeaders creates a deep copy of headers map
-func copyHeaders(headers map[string]string) map[string]string {
+// copyHeaders creates a copy of a header list
+func copyHeaders(headers Headers) Headers {
 	if headers == nil {
 		return nil
 	}
 
-	copy := make(map[string]string, len(headers))
-	for k, v := range headers {
-		copy[k] = v
-	}
-	return copy
+	return append(make(Headers, 0, len(headers)), headers...)
 }
 
 // loadLogsFromDisk loads logs from the disk storage
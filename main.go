@@ -3,7 +3,6 @@ package main
 import (
 	"embed"
 	_ "embed"
-	"fmt"
 	"log"
 	"time"
 
@@ -33,16 +32,20 @@ func main() {
 	collectionService := NewCollectionService()
 	headerService := NewHeaderService()
 	busService := NewEventBusService(eventChannel)
+	settingsService := NewSettingsService()
+	httpService := NewHTTPServiceWithCollection(collectionService)
+	httpService.events = busService
 
 	app := application.New(application.Options{
 		Name:        "captain-api",
 		Description: "A demo of using raw HTML & CSS",
 		Services: []application.Service{
 			application.NewService(&GreetService{}),
-			application.NewService(NewHTTPServiceWithCollection(collectionService)),
+			application.NewService(httpService),
 			application.NewService(collectionService),
 			application.NewService(headerService),
 			application.NewService(busService),
+			application.NewService(settingsService),
 		},
 		Assets: application.AssetOptions{
 			Handler: application.AssetFileServerFS(assets),
@@ -54,7 +57,6 @@ func main() {
 
 	go func() {
 		for event := range eventChannel {
-			fmt.Println("Emitting event from EventBusService:", event.Name, event.Data)
 			app.EmitEvent(event.Name, event.Data)
 		}
 	}()
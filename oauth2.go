@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/browser"
+)
+
+// OAuth 2.0 grant types supported by OAuth2Auth
+const (
+	OAuth2GrantClientCredentials = "client_credentials"
+	OAuth2GrantPassword          = "password"
+	OAuth2GrantRefreshToken      = "refresh_token"
+	OAuth2GrantAuthorizationCode = "authorization_code"
+)
+
+// AuthTypeOAuth2 authenticates requests with an OAuth 2.0 access token
+const AuthTypeOAuth2 = "oauth2"
+
+// oauth2ExpiryLeeway refreshes tokens slightly before they actually expire
+const oauth2ExpiryLeeway = 30 * time.Second
+
+// oauth2AuthorizeTimeout bounds how long we wait for the user to finish the browser login
+const oauth2AuthorizeTimeout = 5 * time.Minute
+
+// OAuth2Auth configures how an access token is obtained from the authorization server
+type OAuth2Auth struct {
+	GrantType    string `json:"grantType"`
+	TokenURL     string `json:"tokenUrl"`
+	AuthURL      string `json:"authUrl,omitempty"` // authorization endpoint, for the authorization code grant
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	Username     string `json:"username,omitempty"`     // password grant
+	Password     string `json:"password,omitempty"`     // password grant
+	RefreshToken string `json:"refreshToken,omitempty"` // refresh_token grant
+	// RedirectPort is the loopback port used for the authorization code redirect; 0 picks a free port
+	RedirectPort int `json:"redirectPort,omitempty"`
+	// ClientAuth is "basic" (default) to send client credentials in the Authorization header, or "body"
+	ClientAuth   string `json:"clientAuth,omitempty"`
+	HeaderPrefix string `json:"headerPrefix,omitempty"`
+}
+
+// OAuth2TokenInfo describes a cached token without exposing its value
+type OAuth2TokenInfo struct {
+	TokenType       string    `json:"tokenType"`
+	Scope           string    `json:"scope,omitempty"`
+	ExpiresAt       time.Time `json:"expiresAt"`
+	HasRefreshToken bool      `json:"hasRefreshToken"`
+}
+
+// oauth2Token is an access token returned by the token endpoint
+type oauth2Token struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string
+	Scope        string
+	Expiry       time.Time
+}
+
+// valid reports whether the token can still be used
+func (t *oauth2Token) valid() bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || time.Now().Add(oauth2ExpiryLeeway).Before(t.Expiry)
+}
+
+// oauth2Manager caches tokens per collection, environment and client configuration
+type oauth2Manager struct {
+	mu     sync.Mutex
+	tokens map[string]*oauth2Token
+	// openBrowser shows the authorization page to the user
+	openBrowser func(url string) error
+}
+
+// newOAuth2Manager creates an empty token cache
+func newOAuth2Manager() *oauth2Manager {
+	return &oauth2Manager{
+		tokens:      make(map[string]*oauth2Token),
+		openBrowser: browser.OpenURL,
+	}
+}
+
+// cacheKey identifies a token by the scope it was obtained in and the client configuration
+func (m *oauth2Manager) cacheKey(scope string, cfg *OAuth2Auth) string {
+	return strings.Join([]string{scope, cfg.GrantType, cfg.TokenURL, cfg.ClientID, cfg.Scope, cfg.Username}, "|")
+}
+
+// token returns a valid access token, refreshing or acquiring a new one when needed.
+// forceRefresh discards the cached token, e.g. after the API answered 401.
+func (m *oauth2Manager) token(ctx context.Context, client *http.Client, scope string, cfg *OAuth2Auth, forceRefresh bool) (*oauth2Token, error) {
+	key := m.cacheKey(scope, cfg)
+
+	m.mu.Lock()
+	cached := m.tokens[key]
+	m.mu.Unlock()
+
+	if cached.valid() && !forceRefresh {
+		return cached, nil
+	}
+
+	var token *oauth2Token
+	var err error
+
+	// Try the refresh token first, falling back to a full grant if the server rejects it
+	if cached != nil && cached.RefreshToken != "" {
+		token, err = m.refresh(ctx, client, cfg, cached.RefreshToken)
+		if err != nil {
+			fmt.Printf("Warning: failed to refresh OAuth2 token: %v\n", err)
+		}
+	}
+	if token == nil {
+		token, err = m.acquire(ctx, client, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep the previous refresh token if the server did not rotate it
+	if token.RefreshToken == "" && cached != nil {
+		token.RefreshToken = cached.RefreshToken
+	}
+
+	m.mu.Lock()
+	m.tokens[key] = token
+	m.mu.Unlock()
+
+	return token, nil
+}
+
+// clear removes every cached token
+func (m *oauth2Manager) clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokens = make(map[string]*oauth2Token)
+}
+
+// acquire runs the configured grant against the token endpoint
+func (m *oauth2Manager) acquire(ctx context.Context, client *http.Client, cfg *OAuth2Auth) (*oauth2Token, error) {
+	form := url.Values{}
+	if cfg.Scope != "" {
+		form.Set("scope", cfg.Scope)
+	}
+
+	switch cfg.GrantType {
+	case "", OAuth2GrantClientCredentials:
+		form.Set("grant_type", OAuth2GrantClientCredentials)
+	case OAuth2GrantPassword:
+		form.Set("grant_type", OAuth2GrantPassword)
+		form.Set("username", cfg.Username)
+		form.Set("password", cfg.Password)
+	case OAuth2GrantRefreshToken:
+		if cfg.RefreshToken == "" {
+			return nil, fmt.Errorf("refresh token grant requires a refresh token")
+		}
+		return m.refresh(ctx, client, cfg, cfg.RefreshToken)
+	case OAuth2GrantAuthorizationCode:
+		return m.authorize(ctx, client, cfg)
+	default:
+		return nil, fmt.Errorf("unsupported OAuth2 grant type %q", cfg.GrantType)
+	}
+
+	return m.exchange(ctx, client, cfg, form)
+}
+
+// refresh exchanges a refresh token for a new access token
+func (m *oauth2Manager) refresh(ctx context.Context, client *http.Client, cfg *OAuth2Auth, refreshToken string) (*oauth2Token, error) {
+	form := url.Values{}
+	form.Set("grant_type", OAuth2GrantRefreshToken)
+	form.Set("refresh_token", refreshToken)
+	if cfg.Scope != "" {
+		form.Set("scope", cfg.Scope)
+	}
+	return m.exchange(ctx, client, cfg, form)
+}
+
+// authorize runs the authorization code flow with PKCE, receiving the code on a loopback listener
+func (m *oauth2Manager) authorize(ctx context.Context, client *http.Client, cfg *OAuth2Auth) (*oauth2Token, error) {
+	if cfg.AuthURL == "" {
+		return nil, fmt.Errorf("authorization code grant requires an authorization URL")
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.RedirectPort)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start redirect listener: %w", err)
+	}
+	defer listener.Close()
+
+	redirectURI := fmt.Sprintf("http://%s/callback", listener.Addr().String())
+	verifier := randomURLSafeString(32)
+	state := randomURLSafeString(16)
+	challenge := sha256.Sum256([]byte(verifier))
+
+	authURL, err := url.Parse(cfg.AuthURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization URL: %w", err)
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", cfg.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("state", state)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	if cfg.Scope != "" {
+		query.Set("scope", cfg.Scope)
+	}
+	authURL.RawQuery = query.Encode()
+
+	type callbackResult struct {
+		code string
+		err  error
+	}
+	results := make(chan callbackResult, 1)
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/callback" {
+				http.NotFound(w, r)
+				return
+			}
+
+			params := r.URL.Query()
+			var result callbackResult
+			switch {
+			case params.Get("error") != "":
+				result.err = fmt.Errorf("authorization failed: %s %s", params.Get("error"), params.Get("error_description"))
+			case params.Get("state") != state:
+				result.err = fmt.Errorf("authorization failed: state mismatch")
+			case params.Get("code") == "":
+				result.err = fmt.Errorf("authorization failed: no code returned")
+			default:
+				result.code = params.Get("code")
+			}
+
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if result.err != nil {
+				fmt.Fprintf(w, "<p>Captain API: %s</p>", result.err)
+			} else {
+				fmt.Fprint(w, "<p>Captain API: authorization complete, you can close this window.</p>")
+			}
+
+			select {
+			case results <- result:
+			default:
+			}
+		}),
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	if err := m.openBrowser(authURL.String()); err != nil {
+		return nil, fmt.Errorf("failed to open browser for authorization: %w", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, oauth2AuthorizeTimeout)
+	defer cancel()
+
+	var result callbackResult
+	select {
+	case result = <-results:
+	case <-waitCtx.Done():
+		return nil, fmt.Errorf("timed out waiting for authorization: %w", waitCtx.Err())
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", OAuth2GrantAuthorizationCode)
+	form.Set("code", result.code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", verifier)
+	return m.exchange(ctx, client, cfg, form)
+}
+
+// exchange posts a token request and parses the token endpoint's response
+func (m *oauth2Manager) exchange(ctx context.Context, client *http.Client, cfg *OAuth2Auth, form url.Values) (*oauth2Token, error) {
+	if cfg.TokenURL == "" {
+		return nil, fmt.Errorf("OAuth2 token URL is required")
+	}
+
+	if cfg.ClientAuth == "body" {
+		form.Set("client_id", cfg.ClientID)
+		if cfg.ClientSecret != "" {
+			form.Set("client_secret", cfg.ClientSecret)
+		}
+	} else if cfg.ClientSecret == "" {
+		// Public clients identify themselves in the body
+		form.Set("client_id", cfg.ClientID)
+	}
+
+	tokenReq, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenReq.Header.Set("Accept", "application/json")
+	if cfg.ClientAuth != "body" && cfg.ClientSecret != "" {
+		tokenReq.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+
+	resp, err := client.Do(tokenReq)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var payload struct {
+		AccessToken      string          `json:"access_token"`
+		TokenType        string          `json:"token_type"`
+		RefreshToken     string          `json:"refresh_token"`
+		Scope            string          `json:"scope"`
+		ExpiresIn        json.RawMessage `json:"expires_in"`
+		Error            string          `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" || mediaType == "text/plain" {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse token response: %w", err)
+		}
+		payload.AccessToken = values.Get("access_token")
+		payload.TokenType = values.Get("token_type")
+		payload.RefreshToken = values.Get("refresh_token")
+		payload.Scope = values.Get("scope")
+		payload.ExpiresIn = json.RawMessage(values.Get("expires_in"))
+		payload.Error = values.Get("error")
+		payload.ErrorDescription = values.Get("error_description")
+	} else if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse token response (status %d): %w", resp.StatusCode, err)
+	}
+
+	if payload.Error != "" {
+		return nil, fmt.Errorf("token endpoint returned %s: %s", payload.Error, payload.ErrorDescription)
+	}
+	if resp.StatusCode >= 400 || payload.AccessToken == "" {
+		return nil, fmt.Errorf("token endpoint returned %s without an access token", resp.Status)
+	}
+
+	token := &oauth2Token{
+		AccessToken:  payload.AccessToken,
+		TokenType:    payload.TokenType,
+		RefreshToken: payload.RefreshToken,
+		Scope:        payload.Scope,
+	}
+	// expires_in is a number, but some servers send it as a string
+	if expiresIn, err := strconv.ParseInt(strings.Trim(string(payload.ExpiresIn), `"`), 10, 64); err == nil && expiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+
+	return token, nil
+}
+
+// authorizationHeader formats the Authorization header value for token
+func (cfg *OAuth2Auth) authorizationHeader(token *oauth2Token) string {
+	prefix := cfg.HeaderPrefix
+	if prefix == "" {
+		prefix = "Bearer"
+	}
+	return prefix + " " + token.AccessToken
+}
+
+// FetchOAuth2Token obtains (or reuses) the OAuth2 token for a request so the UI can acquire one ahead of sending
+func (h *HTTPService) FetchOAuth2Token(ctx context.Context, req HTTPRequest) (*OAuth2TokenInfo, error) {
+	variables, err := h.collectVariables(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve variables: %w", err)
+	}
+	resolver := newVariableResolver(resolvedVariablesToMap(variables))
+
+	effective, err := h.effectiveAuth(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt auth: %w", err)
+	}
+	auth := expandAuth(effective, resolver)
+	if auth == nil || auth.Type != AuthTypeOAuth2 || auth.OAuth2 == nil {
+		return nil, fmt.Errorf("request does not use OAuth2 auth")
+	}
+	if err := resolver.err(); err != nil {
+		return nil, err
+	}
+
+	settings, err := h.requestSettings(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	client, err := h.oauth2Client(settings, auth.OAuth2.TokenURL)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := h.oauth2.token(ctx, client, h.tokenScope(ctx, req.CollectionID), auth.OAuth2, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OAuth2TokenInfo{
+		TokenType:       token.TokenType,
+		Scope:           token.Scope,
+		ExpiresAt:       token.Expiry,
+		HasRefreshToken: token.RefreshToken != "",
+	}, nil
+}
+
+// ClearOAuth2Tokens discards every cached OAuth2 token
+func (h *HTTPService) ClearOAuth2Tokens(ctx context.Context) {
+	h.oauth2.clear()
+}
+
+// tokenScope identifies the collection and active environment that cached tokens and cookies belong to
+func (h *HTTPService) tokenScope(ctx context.Context, collectionID string) string {
+	if collectionID == "" || h.collectionService == nil {
+		return ""
+	}
+
+	env, err := h.collectionService.GetActiveCollectionEnvironment(ctx, collectionID)
+	if err != nil {
+		return collectionID
+	}
+	return collectionID + "/" + env.ID
+}
+
+// randomURLSafeString returns n random bytes encoded as unpadded base64url
+func randomURLSafeString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oauth2Client returns a client for token requests that uses the send's proxy, timeout and
+// the TLS material registered for the token URL's host
+func (h *HTTPService) oauth2Client(settings sendSettings, tokenURL string) (*http.Client, error) {
+	target, err := url.Parse(tokenURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token URL: %w", err)
+	}
+	client, err := h.clientFor(settings, target, newRedirectRecorder(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure TLS for the token URL: %w", err)
+	}
+	return client, nil
+}
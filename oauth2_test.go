@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// newTestHTTPService creates a service whose stores live in a temporary home directory
+func newTestHTTPService(t *testing.T) *HTTPService {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	return NewHTTPService()
+}
+
+// tokenServer is a fake OAuth2 token endpoint recording the grants it was asked for
+type tokenServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	grants []string
+	forms  []url.Values
+	issue  func(form url.Values) (token, refresh string, status int)
+}
+
+// newTokenServer starts a token endpoint that answers with the tokens chosen by issue
+func newTokenServer(t *testing.T, issue func(form url.Values) (string, string, int)) *tokenServer {
+	t.Helper()
+	ts := &tokenServer{issue: issue}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if id, secret, ok := r.BasicAuth(); ok {
+			r.PostForm.Set("basic_client_id", id)
+			r.PostForm.Set("basic_client_secret", secret)
+		}
+
+		ts.mu.Lock()
+		ts.grants = append(ts.grants, r.PostForm.Get("grant_type"))
+		ts.forms = append(ts.forms, r.PostForm)
+		ts.mu.Unlock()
+
+		token, refresh, status := ts.issue(r.PostForm)
+		if status != http.StatusOK {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  token,
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+			"refresh_token": refresh,
+		})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// requests returns the grant types and forms received so far
+func (ts *tokenServer) requests() ([]string, []url.Values) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]string(nil), ts.grants...), append([]url.Values(nil), ts.forms...)
+}
+
+// newBearerAPI starts an API answering 200 only for the bearer token returned by valid
+func newBearerAPI(t *testing.T, valid func() string) *httptest.Server {
+	t.Helper()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+valid() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	h := newTestHTTPService(t)
+	tokens := newTokenServer(t, func(form url.Values) (string, string, int) {
+		if form.Get("basic_client_id") != "client" || form.Get("basic_client_secret") != "s3cret" {
+			return "", "", http.StatusUnauthorized
+		}
+		return "access-1", "", http.StatusOK
+	})
+	api := newBearerAPI(t, func() string { return "access-1" })
+
+	req := HTTPRequest{
+		Method: http.MethodGet,
+		URL:    api.URL,
+		Auth: &RequestAuth{Type: AuthTypeOAuth2, OAuth2: &OAuth2Auth{
+			GrantType:    OAuth2GrantClientCredentials,
+			TokenURL:     tokens.URL,
+			ClientID:     "client",
+			ClientSecret: "s3cret",
+			Scope:        "read",
+		}},
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := h.SendRequest(context.Background(), req)
+		if err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("send %d: status %d, want 200", i, resp.StatusCode)
+		}
+	}
+
+	grants, forms := tokens.requests()
+	if len(grants) != 1 || grants[0] != OAuth2GrantClientCredentials {
+		t.Fatalf("token requests = %v, want one client_credentials grant reused from the cache", grants)
+	}
+	if forms[0].Get("scope") != "read" {
+		t.Errorf("scope = %q, want read", forms[0].Get("scope"))
+	}
+}
+
+func TestOAuth2RefreshOn401(t *testing.T) {
+	h := newTestHTTPService(t)
+	tokens := newTokenServer(t, func(form url.Values) (string, string, int) {
+		switch form.Get("grant_type") {
+		case OAuth2GrantClientCredentials:
+			return "revoked", "refresh-1", http.StatusOK
+		case OAuth2GrantRefreshToken:
+			if form.Get("refresh_token") != "refresh-1" {
+				return "", "", http.StatusBadRequest
+			}
+			return "fresh", "", http.StatusOK
+		}
+		return "", "", http.StatusBadRequest
+	})
+	api := newBearerAPI(t, func() string { return "fresh" })
+
+	resp, err := h.SendRequest(context.Background(), HTTPRequest{
+		Method: http.MethodGet,
+		URL:    api.URL,
+		Auth: &RequestAuth{Type: AuthTypeOAuth2, OAuth2: &OAuth2Auth{
+			TokenURL:     tokens.URL,
+			ClientID:     "client",
+			ClientSecret: "s3cret",
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200 after refreshing the token", resp.StatusCode)
+	}
+
+	grants, _ := tokens.requests()
+	want := []string{OAuth2GrantClientCredentials, OAuth2GrantRefreshToken}
+	if len(grants) != len(want) || grants[0] != want[0] || grants[1] != want[1] {
+		t.Fatalf("token requests = %v, want %v", grants, want)
+	}
+}
+
+func TestOAuth2AuthorizationCodePKCE(t *testing.T) {
+	h := newTestHTTPService(t)
+
+	var challenge string
+	tokens := newTokenServer(t, func(form url.Values) (string, string, int) {
+		sum := sha256.Sum256([]byte(form.Get("code_verifier")))
+		if form.Get("code") != "auth-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			return "", "", http.StatusBadRequest
+		}
+		return "pkce-token", "", http.StatusOK
+	})
+	api := newBearerAPI(t, func() string { return "pkce-token" })
+
+	// Play the user: approve in the "browser" by following the redirect with a code
+	browserErrs := make(chan error, 1)
+	h.oauth2.openBrowser = func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		query := u.Query()
+		if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "public" {
+			t.Errorf("unexpected authorization request %s", authURL)
+		}
+		challenge = query.Get("code_challenge")
+
+		redirect, err := url.Parse(query.Get("redirect_uri"))
+		if err != nil {
+			return err
+		}
+		redirect.RawQuery = url.Values{"code": {"auth-code"}, "state": {query.Get("state")}}.Encode()
+		go func() {
+			resp, err := http.Get(redirect.String())
+			if err == nil {
+				resp.Body.Close()
+			}
+			browserErrs <- err
+		}()
+		return nil
+	}
+
+	resp, err := h.SendRequest(context.Background(), HTTPRequest{
+		Method: http.MethodGet,
+		URL:    api.URL,
+		Auth: &RequestAuth{Type: AuthTypeOAuth2, OAuth2: &OAuth2Auth{
+			GrantType: OAuth2GrantAuthorizationCode,
+			AuthURL:   "https://auth.example.com/authorize",
+			TokenURL:  tokens.URL,
+			ClientID:  "public",
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-browserErrs; err != nil {
+		t.Fatalf("redirect to the loopback listener failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200", resp.StatusCode)
+	}
+
+	_, forms := tokens.requests()
+	if len(forms) != 1 || forms[0].Get("client_id") != "public" {
+		t.Fatalf("token requests = %v, want one exchange identifying the public client", forms)
+	}
+}
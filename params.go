@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// QueryParam is an editable query string row; disabled rows are kept but not sent
+type QueryParam struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Enabled     bool   `json:"enabled"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathParam is the value for a :name segment in the request URL path
+type PathParam struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+}
+
+// ParsedURL is a URL split into its base and editable parameter rows
+type ParsedURL struct {
+	URL        string       `json:"url"` // the URL without its query string
+	Params     []QueryParam `json:"params"`
+	PathParams []PathParam  `json:"pathParams"`
+}
+
+// ParseURLParams splits a URL into query parameter rows and the :name path parameters it references.
+// Placeholders such as {{baseUrl}} are left untouched.
+func (h *HTTPService) ParseURLParams(ctx context.Context, rawURL string) (*ParsedURL, error) {
+	base, query, _ := strings.Cut(strings.TrimSpace(rawURL), "?")
+	query, _, _ = strings.Cut(query, "#")
+
+	parsed := &ParsedURL{
+		URL:        base,
+		Params:     []QueryParam{},
+		PathParams: []PathParam{},
+	}
+
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		parsed.Params = append(parsed.Params, QueryParam{
+			Key:     unescapeQueryPart(key),
+			Value:   unescapeQueryPart(value),
+			Enabled: true,
+		})
+	}
+
+	for _, name := range pathParamNames(base) {
+		parsed.PathParams = append(parsed.PathParams, PathParam{Key: name})
+	}
+
+	return parsed, nil
+}
+
+// buildRequestURL fills in :name path segments and appends the enabled query params, percent-encoded
+func buildRequestURL(rawURL string, params []QueryParam, pathParams []PathParam) string {
+	base, fragment, hasFragment := strings.Cut(rawURL, "#")
+	base, query, hasQuery := strings.Cut(base, "?")
+
+	if len(pathParams) > 0 {
+		values := make(map[string]string, len(pathParams))
+		for _, p := range pathParams {
+			if p.Key != "" {
+				values[p.Key] = p.Value
+			}
+		}
+
+		start := pathStart(base)
+		segments := strings.Split(base[start:], "/")
+		for i, segment := range segments {
+			if value, ok := values[strings.TrimPrefix(segment, ":")]; ok && strings.HasPrefix(segment, ":") {
+				segments[i] = url.PathEscape(value)
+			}
+		}
+		base = base[:start] + strings.Join(segments, "/")
+	}
+
+	var pairs []string
+	if query != "" {
+		pairs = append(pairs, query)
+	}
+	for _, p := range params {
+		if !p.Enabled || p.Key == "" {
+			continue
+		}
+		pairs = append(pairs, encodeQueryPart(p.Key)+"="+encodeQueryPart(p.Value))
+	}
+
+	result := base
+	if len(pairs) > 0 || hasQuery {
+		result += "?" + strings.Join(pairs, "&")
+	}
+	if hasFragment {
+		result += "#" + fragment
+	}
+	return result
+}
+
+// pathParamNames returns the names of the :name segments in a URL, in order
+func pathParamNames(rawURL string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, segment := range strings.Split(rawURL[pathStart(rawURL):], "/") {
+		name := strings.TrimPrefix(segment, ":")
+		if name == segment || name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// pathStart returns the index where the path begins, skipping the scheme and host so ports are not taken for params
+func pathStart(rawURL string) int {
+	_, rest, ok := strings.Cut(rawURL, "://")
+	if !ok {
+		return 0
+	}
+	offset := len(rawURL) - len(rest)
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return offset + i
+	}
+	return len(rawURL)
+}
+
+// encodeQueryPart percent-encodes a query key or value, using %20 rather than + for spaces
+func encodeQueryPart(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// unescapeQueryPart decodes a query key or value, keeping the raw text if it is not valid encoding
+func unescapeQueryPart(s string) string {
+	if decoded, err := url.QueryUnescape(s); err == nil {
+		return decoded
+	}
+	return s
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy modes for ProxySettings.Mode
+const (
+	ProxyModeNone        = "none"
+	ProxyModeSystem      = "system"
+	ProxyModeEnvironment = "environment"
+	ProxyModeManual      = "manual"
+)
+
+// Proxy types for manual proxies
+const (
+	ProxyTypeHTTP   = "http"
+	ProxyTypeHTTPS  = "https"
+	ProxyTypeSOCKS5 = "socks5"
+)
+
+// ProxySettings configures the proxy requests are sent through. The system mode uses the
+// proxy configured in Windows Internet Options or macOS network settings and falls back to
+// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables when none is set, or on
+// other platforms. The environment mode only reads the environment variables. Proxy
+// auto-config (PAC) scripts are not evaluated.
+type ProxySettings struct {
+	Mode     string   `json:"mode"`           // none, system (default), environment or manual
+	Type     string   `json:"type,omitempty"` // http (default), https or socks5
+	Host     string   `json:"host,omitempty"`
+	Port     int      `json:"port,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"` // encrypted at rest
+	NoProxy  []string `json:"noProxy,omitempty"`  // hosts reached directly, e.g. localhost or *.internal.example.com
+}
+
+// validate checks a manual proxy has everything needed to build its URL
+func (p *ProxySettings) validate() error {
+	switch p.Mode {
+	case "", ProxyModeNone, ProxyModeSystem, ProxyModeEnvironment:
+		return nil
+	case ProxyModeManual:
+	default:
+		return fmt.Errorf("unsupported proxy mode %q", p.Mode)
+	}
+
+	switch p.Type {
+	case "", ProxyTypeHTTP, ProxyTypeHTTPS, ProxyTypeSOCKS5:
+	default:
+		return fmt.Errorf("unsupported proxy type %q", p.Type)
+	}
+	if p.Host == "" {
+		return fmt.Errorf("manual proxy requires a host")
+	}
+	if p.Port <= 0 || p.Port > 65535 {
+		return fmt.Errorf("invalid proxy port %d", p.Port)
+	}
+	return nil
+}
+
+// proxyConfig is a resolved proxy setting, ready to be used by a transport.
+// A system config without proxy URLs uses the environment variables.
+type proxyConfig struct {
+	mode     string
+	httpURL  *url.URL // proxy for http:// targets
+	httpsURL *url.URL // proxy for https:// targets
+	noProxy  []string
+}
+
+// key identifies the proxy configuration for transport caching
+func (c proxyConfig) key() string {
+	if c.httpURL == nil && c.httpsURL == nil {
+		return c.mode
+	}
+	return c.mode + "|" + urlString(c.httpURL) + "|" + urlString(c.httpsURL) + "|" + strings.Join(c.noProxy, ",")
+}
+
+// urlString formats u, or returns "" when it is nil
+func urlString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+// proxyFunc returns the transport Proxy function for the configuration
+func (c proxyConfig) proxyFunc() func(*http.Request) (*url.URL, error) {
+	if c.mode == ProxyModeNone {
+		return nil
+	}
+	if c.httpURL == nil && c.httpsURL == nil {
+		return http.ProxyFromEnvironment
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(c.noProxy, req.URL) {
+			return nil, nil
+		}
+		if req.URL.Scheme == "https" || req.URL.Scheme == "wss" {
+			return c.httpsURL, nil
+		}
+		return c.httpURL, nil
+	}
+}
+
+// resolveProxy builds the proxy configuration from settings, decrypting the password
+func resolveProxy(settings *ProxySettings, secrets *secretKeeper) (proxyConfig, error) {
+	if settings == nil || settings.Mode == "" || settings.Mode == ProxyModeSystem {
+		if system := systemProxy(); system != nil {
+			return *system, nil
+		}
+		return proxyConfig{mode: ProxyModeSystem}, nil
+	}
+	if err := settings.validate(); err != nil {
+		return proxyConfig{}, err
+	}
+	if settings.Mode == ProxyModeNone || settings.Mode == ProxyModeEnvironment {
+		return proxyConfig{mode: settings.Mode}, nil
+	}
+
+	scheme := settings.Type
+	if scheme == "" {
+		scheme = ProxyTypeHTTP
+	}
+	proxyURL := &url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(settings.Host, strconv.Itoa(settings.Port)),
+	}
+
+	if settings.Username != "" {
+		password, err := secrets.decrypt(settings.Password)
+		if err != nil {
+			return proxyConfig{}, fmt.Errorf("failed to decrypt proxy password: %w", err)
+		}
+		proxyURL.User = url.UserPassword(settings.Username, password)
+	}
+
+	return proxyConfig{mode: ProxyModeManual, httpURL: proxyURL, httpsURL: proxyURL, noProxy: settings.NoProxy}, nil
+}
+
+// systemProxyTTL is how long the operating system's proxy settings are reused before being read again
+const systemProxyTTL = 10 * time.Second
+
+// systemProxyCache holds the last proxy settings read from the operating system
+var systemProxyCache struct {
+	sync.Mutex
+	loaded time.Time
+	config *proxyConfig
+}
+
+// systemProxy returns the operating system's proxy configuration, or nil when it has none
+// or it cannot be read. Settings are cached briefly since reading them may run a command.
+func systemProxy() *proxyConfig {
+	systemProxyCache.Lock()
+	defer systemProxyCache.Unlock()
+	if time.Since(systemProxyCache.loaded) > systemProxyTTL {
+		systemProxyCache.config = loadSystemProxy()
+		systemProxyCache.loaded = time.Now()
+	}
+	return systemProxyCache.config
+}
+
+// parseWinINETProxy parses the ProxyServer and ProxyOverride values of the Windows Internet
+// Settings. The server is either "host:port" for every scheme or a list such as
+// "http=host:port;https=host:port;socks=host:port"; SOCKS is used for schemes without their own entry.
+func parseWinINETProxy(server, override string) *proxyConfig {
+	config := &proxyConfig{mode: ProxyModeSystem}
+	var socks *url.URL
+	for _, entry := range strings.Split(server, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		scheme, address, found := strings.Cut(entry, "=")
+		if !found {
+			address = entry
+			scheme = ""
+		}
+		switch strings.ToLower(scheme) {
+		case "":
+			config.httpURL = proxyURLFromAddress(ProxyTypeHTTP, address)
+			config.httpsURL = config.httpURL
+		case "http":
+			config.httpURL = proxyURLFromAddress(ProxyTypeHTTP, address)
+		case "https":
+			config.httpsURL = proxyURLFromAddress(ProxyTypeHTTP, address)
+		case "socks":
+			socks = proxyURLFromAddress(ProxyTypeSOCKS5, address)
+		}
+	}
+	if config.httpURL == nil {
+		config.httpURL = socks
+	}
+	if config.httpsURL == nil {
+		config.httpsURL = socks
+	}
+	if config.httpURL == nil && config.httpsURL == nil {
+		return nil
+	}
+
+	for _, pattern := range strings.Split(override, ";") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			config.noProxy = append(config.noProxy, pattern)
+		}
+	}
+	return config
+}
+
+// parseScutilProxy parses the output of "scutil --proxy" on macOS
+func parseScutilProxy(output string) *proxyConfig {
+	values := make(map[string]string)
+	config := &proxyConfig{mode: ProxyModeSystem}
+	inExceptions := false
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "}" {
+			inExceptions = false
+			continue
+		}
+		key, value, found := strings.Cut(line, " : ")
+		if !found {
+			continue
+		}
+		if inExceptions {
+			config.noProxy = append(config.noProxy, value)
+			continue
+		}
+		if key == "ExceptionsList" {
+			inExceptions = true
+			continue
+		}
+		values[key] = value
+	}
+
+	if values["HTTPEnable"] == "1" {
+		config.httpURL = proxyURLFromAddress(ProxyTypeHTTP, net.JoinHostPort(values["HTTPProxy"], values["HTTPPort"]))
+	}
+	if values["HTTPSEnable"] == "1" {
+		config.httpsURL = proxyURLFromAddress(ProxyTypeHTTP, net.JoinHostPort(values["HTTPSProxy"], values["HTTPSPort"]))
+	}
+	if values["SOCKSEnable"] == "1" {
+		socks := proxyURLFromAddress(ProxyTypeSOCKS5, net.JoinHostPort(values["SOCKSProxy"], values["SOCKSPort"]))
+		if config.httpURL == nil {
+			config.httpURL = socks
+		}
+		if config.httpsURL == nil {
+			config.httpsURL = socks
+		}
+	}
+	if config.httpURL == nil && config.httpsURL == nil {
+		return nil
+	}
+	if values["ExcludeSimpleHostnames"] == "1" {
+		config.noProxy = append(config.noProxy, "<local>")
+	}
+	return config
+}
+
+// proxyURLFromAddress builds a proxy URL from a "host:port" address, or returns nil
+// when the address is unusable. An address that already has a scheme keeps it.
+func proxyURLFromAddress(scheme, address string) *url.URL {
+	if !strings.Contains(address, "://") {
+		address = scheme + "://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+	return u
+}
+
+// bypassProxy reports whether target matches one of the no-proxy host patterns.
+// A leading dot is treated like "*." so NO_PROXY-style entries work too, and the
+// system settings' forms are understood: "<local>" for hosts without a dot,
+// wildcards anywhere such as "192.168.*", and CIDR ranges.
+func bypassProxy(noProxy []string, target *url.URL) bool {
+	port := target.Port()
+	if port == "" {
+		port = "443"
+		if target.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	for _, pattern := range noProxy {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if strings.HasPrefix(pattern, ".") {
+			pattern = "*" + pattern
+		}
+		if matchHostPattern(pattern, target.Hostname(), port) >= 0 {
+			return true
+		}
+		if bypassSystemPattern(pattern, target.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// bypassSystemPattern matches the no-proxy forms used by operating system proxy settings
+func bypassSystemPattern(pattern, host string) bool {
+	host = strings.ToLower(host)
+	if pattern == "<local>" {
+		return !strings.Contains(host, ".") && !strings.Contains(host, ":")
+	}
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && network.Contains(ip)
+	}
+	if strings.Contains(pattern, "*") {
+		matched, err := path.Match(strings.ToLower(pattern), host)
+		return err == nil && matched
+	}
+	return false
+}
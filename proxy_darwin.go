@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os/exec"
+)
+
+// loadSystemProxy reads the proxy of the active network service from scutil, or returns nil
+// when none is enabled or scutil cannot be run
+func loadSystemProxy() *proxyConfig {
+	output, err := exec.Command("scutil", "--proxy").Output()
+	if err != nil {
+		return nil
+	}
+	return parseScutilProxy(string(output))
+}
@@ -0,0 +1,9 @@
+//go:build !windows && !darwin
+
+package main
+
+// loadSystemProxy returns nil since other platforms have no common proxy setting besides
+// the environment variables, which the system mode falls back to
+func loadSystemProxy() *proxyConfig {
+	return nil
+}
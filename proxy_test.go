@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseWinINETProxy(t *testing.T) {
+	tests := []struct {
+		server, override string
+		http, https      string
+		noProxy          int
+	}{
+		{"proxy.corp:8080", "", "http://proxy.corp:8080", "http://proxy.corp:8080", 0},
+		{"http=web.corp:80;https=secure.corp:443", "<local>;*.corp", "http://web.corp:80", "http://secure.corp:443", 2},
+		{"socks=socks.corp:1080", "", "socks5://socks.corp:1080", "socks5://socks.corp:1080", 0},
+		{"http=web.corp:80;socks=socks.corp:1080", "", "http://web.corp:80", "socks5://socks.corp:1080", 0},
+	}
+	for _, tt := range tests {
+		config := parseWinINETProxy(tt.server, tt.override)
+		if config == nil {
+			t.Errorf("parseWinINETProxy(%q) = nil", tt.server)
+			continue
+		}
+		if got := urlString(config.httpURL); got != tt.http {
+			t.Errorf("parseWinINETProxy(%q) http = %q, want %q", tt.server, got, tt.http)
+		}
+		if got := urlString(config.httpsURL); got != tt.https {
+			t.Errorf("parseWinINETProxy(%q) https = %q, want %q", tt.server, got, tt.https)
+		}
+		if len(config.noProxy) != tt.noProxy {
+			t.Errorf("parseWinINETProxy(%q) noProxy = %v, want %d entries", tt.server, config.noProxy, tt.noProxy)
+		}
+	}
+
+	if config := parseWinINETProxy("", ""); config != nil {
+		t.Errorf("parseWinINETProxy(\"\") = %+v, want nil", config)
+	}
+}
+
+func TestParseScutilProxy(t *testing.T) {
+	output := `<dictionary> {
+  ExceptionsList : <array> {
+    0 : *.local
+    1 : 10.0.0.0/8
+  }
+  ExcludeSimpleHostnames : 1
+  FTPPassive : 1
+  HTTPEnable : 1
+  HTTPPort : 3128
+  HTTPProxy : proxy.example.com
+  HTTPSEnable : 1
+  HTTPSPort : 3129
+  HTTPSProxy : proxy.example.com
+}
+`
+	config := parseScutilProxy(output)
+	if config == nil {
+		t.Fatal("parseScutilProxy returned nil for an enabled proxy")
+	}
+	if got := urlString(config.httpURL); got != "http://proxy.example.com:3128" {
+		t.Errorf("http = %q", got)
+	}
+	if got := urlString(config.httpsURL); got != "http://proxy.example.com:3129" {
+		t.Errorf("https = %q", got)
+	}
+
+	proxy := config.proxyFunc()
+	for target, direct := range map[string]bool{
+		"https://api.example.com/": false,
+		"http://printer.local/":    true,
+		"http://10.1.2.3/":         true,
+		"http://intranet/":         true,
+	} {
+		u, _ := url.Parse(target)
+		got, err := proxy(&http.Request{URL: u})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (got == nil) != direct {
+			t.Errorf("proxy for %s = %v, want direct %v", target, got, direct)
+		}
+	}
+
+	if config := parseScutilProxy("<dictionary> {\n  HTTPEnable : 0\n}\n"); config != nil {
+		t.Errorf("parseScutilProxy with proxies disabled = %+v, want nil", config)
+	}
+}
+
+func TestBypassProxyWildcards(t *testing.T) {
+	noProxy := []string{"192.168.*", ".internal.example.com"}
+	for target, want := range map[string]bool{
+		"http://192.168.1.20/":              true,
+		"http://192.169.1.20/":              false,
+		"https://api.internal.example.com/": true,
+		"https://api.external.example.com/": false,
+	} {
+		u, _ := url.Parse(target)
+		if got := bypassProxy(noProxy, u); got != want {
+			t.Errorf("bypassProxy(%s) = %v, want %v", target, got, want)
+		}
+	}
+}
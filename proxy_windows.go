@@ -0,0 +1,29 @@
+package main
+
+import (
+	"golang.org/x/sys/windows/registry"
+)
+
+// internetSettingsKey holds the per-user proxy configured in Internet Options
+const internetSettingsKey = `Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+
+// loadSystemProxy reads the manual proxy from the Windows Internet Settings, or returns nil
+// when the proxy is disabled or the settings cannot be read
+func loadSystemProxy() *proxyConfig {
+	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.QUERY_VALUE)
+	if err != nil {
+		return nil
+	}
+	defer key.Close()
+
+	enabled, _, err := key.GetIntegerValue("ProxyEnable")
+	if err != nil || enabled == 0 {
+		return nil
+	}
+	server, _, err := key.GetStringValue("ProxyServer")
+	if err != nil {
+		return nil
+	}
+	override, _, _ := key.GetStringValue("ProxyOverride")
+	return parseWinINETProxy(server, override)
+}
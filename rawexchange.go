@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// rawRequest renders the request as sent: the dumped request line and headers followed by
+// the body. Binary, compressed and bodies over maxInlineBody are summarised instead of inlined.
+func rawRequest(dump []byte, body *requestBody, contentType string) (string, error) {
+	raw := string(dump)
+	if len(body.segments) == 0 {
+		return raw, nil
+	}
+	if body.contentEncoding != "" {
+		// Compressing again just to show binary data is not worth it
+		return raw + fmt.Sprintf("[%s compressed body, %d bytes before compression]", body.contentEncoding, body.size()), nil
+	}
+
+	r, err := body.open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(io.LimitReader(r, maxInlineBody))
+	if err != nil {
+		return "", err
+	}
+	return raw + rawBodyText(data, body.size(), contentType, false), nil
+}
+
+// rawResponse renders the response as received: the status line and headers followed by the
+// body as returned in HTTPResponse.Body
+func rawResponse(resp *http.Response, body *responseBody) (string, error) {
+	dump, err := httputil.DumpResponse(resp, false)
+	if err != nil {
+		return "", err
+	}
+	if body.binary {
+		return string(dump) + rawBodyText(body.data, body.size, "", true), nil
+	}
+
+	raw := string(dump) + body.decoded
+	if body.truncated {
+		raw += fmt.Sprintf("\n[... %d more bytes]", body.size-int64(len(body.data)))
+	}
+	return raw, nil
+}
+
+// rawBodyText returns a body for a raw exchange, summarising binary or oversized content
+func rawBodyText(data []byte, size int64, contentType string, binary bool) string {
+	if !binary {
+		_, binary = detectBodyType(contentType, data, int64(len(data)) < size)
+	}
+	switch {
+	case binary:
+		return fmt.Sprintf("[binary body, %d bytes]", size)
+	case int64(len(data)) < size:
+		return string(data) + fmt.Sprintf("\n[... %d more bytes]", size-int64(len(data)))
+	default:
+		return string(data)
+	}
+}
+
+// credentialHeaders returns the request headers that carry credentials for auth
+func credentialHeaders(auth *RequestAuth) []string {
+	headers := []string{"Authorization", "Proxy-Authorization", "Cookie"}
+	if auth == nil {
+		return headers
+	}
+
+	switch auth.Type {
+	case AuthTypeAPIKey:
+		if auth.APIKey != nil && (auth.APIKey.In == "" || strings.EqualFold(auth.APIKey.In, "header")) {
+			headers = append(headers, auth.APIKey.Key)
+		}
+	case AuthTypeAWSSigV4:
+		headers = append(headers, "X-Amz-Security-Token")
+	case AuthTypeHMAC:
+		if auth.HMAC != nil {
+			header := auth.HMAC.Header
+			if header == "" {
+				header = "X-Signature"
+			}
+			headers = append(headers, header)
+		}
+	case AuthTypeJWT:
+		if auth.JWT != nil && auth.JWT.Header != "" {
+			headers = append(headers, auth.JWT.Header)
+		}
+	}
+	return headers
+}
+
+// redactRawHeaders returns a raw message with the values of the named headers replaced by
+// secretMask. Only the header block is touched; the body is left as is.
+func redactRawHeaders(raw string, names []string) string {
+	head, body, found := strings.Cut(raw, "\r\n\r\n")
+	lines := strings.Split(head, "\r\n")
+	for i, line := range lines[1:] {
+		name, _, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		for _, n := range names {
+			if strings.EqualFold(strings.TrimSpace(name), n) {
+				lines[i+1] = name + ": " + secretMask
+				break
+			}
+		}
+	}
+
+	redacted := strings.Join(lines, "\r\n")
+	if found {
+		redacted += "\r\n\r\n" + body
+	}
+	return redacted
+}
+
+// credentialQueryParams returns the query parameters that carry credentials for auth
+func credentialQueryParams(auth *RequestAuth) []string {
+	if auth == nil || auth.Type != AuthTypeAPIKey || auth.APIKey == nil || !strings.EqualFold(auth.APIKey.In, "query") {
+		return nil
+	}
+	return []string{auth.APIKey.Key}
+}
+
+// redactRequestLine returns a raw request with the values of the named query parameters in
+// its request line replaced by secretMask
+func redactRequestLine(raw string, params []string) string {
+	line, rest, found := strings.Cut(raw, "\r\n")
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 {
+		return raw
+	}
+	parts[1] = redactQueryParams(parts[1], params)
+
+	redacted := strings.Join(parts, " ")
+	if found {
+		redacted += "\r\n" + rest
+	}
+	return redacted
+}
+
+// redactQueryParams returns rawURL with the values of the named query parameters replaced by
+// secretMask. The rest of the URL is kept byte for byte.
+func redactQueryParams(rawURL string, params []string) string {
+	if len(params) == 0 {
+		return rawURL
+	}
+	base, query, found := strings.Cut(rawURL, "?")
+	if !found {
+		return rawURL
+	}
+	query, fragment, hasFragment := strings.Cut(query, "#")
+
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		for _, param := range params {
+			if name == param {
+				pairs[i] = key + "=" + secretMask
+				break
+			}
+		}
+	}
+
+	redacted := base + "?" + strings.Join(pairs, "&")
+	if hasFragment {
+		redacted += "#" + fragment
+	}
+	return redacted
+}
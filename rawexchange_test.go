@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRawRequestRedactsQueryAPIKey(t *testing.T) {
+	ctx := context.Background()
+	const key = "k3y-s3cret"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api_key") != key {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/start" {
+			http.Redirect(w, r, "/final?"+r.URL.RawQuery, http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+
+	h := newTestHTTPService(t)
+	if err := h.settingsService.SaveSettings(ctx, AppSettings{LogRawExchange: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := h.SendRequest(ctx, HTTPRequest{
+		Method: http.MethodGet,
+		URL:    srv.URL + "/start?page=2",
+		Auth:   &RequestAuth{Type: AuthTypeAPIKey, APIKey: &APIKeyAuth{Key: "api_key", Value: key, In: "query"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(resp.Redirects) != 1 {
+		t.Fatalf("status %d with %d redirects, want 200 after one redirect", resp.StatusCode, len(resp.Redirects))
+	}
+
+	logs, err := h.GetRequestLogs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("logs = %d, want 1", len(logs))
+	}
+	if !strings.HasPrefix(logs[0].RawRequest, "GET /start?page=2&api_key="+secretMask+" HTTP/1.1\r\n") {
+		t.Errorf("raw request line not redacted:\n%s", logs[0].RawRequest)
+	}
+	entry, err := json.Marshal(logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(entry), key) {
+		t.Errorf("log entry contains the API key:\n%s", entry)
+	}
+}
+
+func TestCredentialHeadersAWS(t *testing.T) {
+	headers := credentialHeaders(&RequestAuth{Type: AuthTypeAWSSigV4, AWSSigV4: &AWSSigV4Auth{SessionToken: "token"}})
+	raw := "GET / HTTP/1.1\r\nAuthorization: AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request\r\n" +
+		"X-Amz-Security-Token: token\r\n\r\n"
+
+	redacted := redactRawHeaders(raw, headers)
+	if strings.Contains(redacted, "AKIDEXAMPLE") || strings.Contains(redacted, "token\r\n") {
+		t.Errorf("AWS credentials not redacted:\n%s", redacted)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RedirectHop is a redirect response that was followed on the way to the final response,
+// or the redirect that stopped the chain when the redirect limit was reached
+type RedirectHop struct {
+	Method     string  `json:"method"`
+	URL        string  `json:"url"`
+	StatusCode int     `json:"statusCode"`
+	Status     string  `json:"status"`
+	Location   string  `json:"location"`
+	Headers    Headers `json:"headers"`  // sorted by name, as for HTTPResponse.Headers
+	Duration   int64   `json:"duration"` // in milliseconds, from sending the hop's request to following it
+}
+
+// redirectRecorder collects the hops of one send from the client's CheckRedirect hook
+type redirectRecorder struct {
+	hopStart     time.Time
+	hops         []RedirectHop
+	limitReached bool
+}
+
+// newRedirectRecorder starts timing the first hop
+func newRedirectRecorder() *redirectRecorder {
+	return &redirectRecorder{hopStart: time.Now()}
+}
+
+// reset forgets the hops recorded so far, before the request is sent again
+func (r *redirectRecorder) reset() {
+	r.hopStart = time.Now()
+	r.hops = nil
+	r.limitReached = false
+}
+
+// record adds the redirect that led to next, which the client is about to send
+func (r *redirectRecorder) record(next *http.Request, via []*http.Request) {
+	now := time.Now()
+	prev := via[len(via)-1]
+	resp := next.Response
+
+	hop := RedirectHop{
+		Method:   prev.Method,
+		URL:      prev.URL.String(),
+		Duration: now.Sub(r.hopStart).Milliseconds(),
+	}
+	if resp != nil {
+		hop.StatusCode = resp.StatusCode
+		hop.Status = resp.Status
+		hop.Location = resp.Header.Get("Location")
+		hop.Headers = headersFromHTTP(resp.Header)
+	}
+
+	r.hops = append(r.hops, hop)
+	r.hopStart = now
+}
+
+// maskRedirectSecrets returns a copy of hops with secret values masked for logging
+func maskRedirectSecrets(hops []RedirectHop, secrets []string) []RedirectHop {
+	if hops == nil {
+		return nil
+	}
+
+	masked := make([]RedirectHop, len(hops))
+	for i, hop := range hops {
+		hop.URL = maskSecrets(hop.URL, secrets)
+		hop.Location = maskSecrets(hop.Location, secrets)
+		hop.Headers = maskHeaderSecrets(hop.Headers, secrets)
+		masked[i] = hop
+	}
+	return masked
+}
+
+// redactRedirectParams returns a copy of hops with the values of the named query parameters
+// in their URLs and Location headers replaced by secretMask
+func redactRedirectParams(hops []RedirectHop, params []string) []RedirectHop {
+	if hops == nil || len(params) == 0 {
+		return hops
+	}
+
+	redacted := make([]RedirectHop, len(hops))
+	for i, hop := range hops {
+		hop.URL = redactQueryParams(hop.URL, params)
+		hop.Location = redactQueryParams(hop.Location, params)
+		hop.Headers = copyHeaders(hop.Headers)
+		for j, header := range hop.Headers {
+			if strings.EqualFold(header.Key, "Location") {
+				hop.Headers[j].Value = redactQueryParams(header.Value, params)
+			}
+		}
+		redacted[i] = hop
+	}
+	return redacted
+}
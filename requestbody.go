@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Body modes for HTTPRequest.BodyMode. An empty mode behaves like raw.
+const (
+	BodyModeNone       = "none"
+	BodyModeRaw        = "raw"
+	BodyModeJSON       = "json"
+	BodyModeURLEncoded = "urlencoded"
+	BodyModeFormData   = "formdata"
+	BodyModeBinary     = "binary"
+)
+
+// Form field types for FormField.Type
+const (
+	FormFieldText = "text"
+	FormFieldFile = "file"
+)
+
+// KeyValue is an editable name/value row that can be switched off without deleting it
+type KeyValue struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Enabled bool   `json:"enabled"`
+}
+
+// FormField is a multipart/form-data part, either a text value or a file read from disk
+type FormField struct {
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty"`
+	Type        string `json:"type"`                  // "text" (default) or "file"
+	Src         string `json:"src,omitempty"`         // path of the file to upload
+	ContentType string `json:"contentType,omitempty"` // defaults to a type guessed from the file name
+	Enabled     bool   `json:"enabled"`
+}
+
+// bodySegment is a piece of a request body, either in memory or streamed from a file
+type bodySegment struct {
+	data []byte
+	path string
+	size int64
+}
+
+// requestBody is a replayable request body with its content type
+type requestBody struct {
+	segments        []bodySegment
+	contentType     string
+	contentEncoding string
+	// summary describes the body for request logs without inlining uploaded files
+	summary string
+}
+
+// size returns the total uncompressed body length in bytes
+func (b *requestBody) size() int64 {
+	var n int64
+	for _, s := range b.segments {
+		n += s.size
+	}
+	return n
+}
+
+// open returns a fresh reader over the whole body as sent, compressing it on the fly when
+// a content coding is set
+func (b *requestBody) open() (io.ReadCloser, error) {
+	r, err := b.openSegments()
+	if err != nil || b.contentEncoding == "" {
+		return r, err
+	}
+	return compressReader(r, b.contentEncoding)
+}
+
+// openSegments returns a reader over the uncompressed body, opening any files it streams from
+func (b *requestBody) openSegments() (io.ReadCloser, error) {
+	readers := make([]io.Reader, 0, len(b.segments))
+	var files []*os.File
+	for _, s := range b.segments {
+		if s.path == "" {
+			readers = append(readers, bytes.NewReader(s.data))
+			continue
+		}
+		f, err := os.Open(s.path)
+		if err != nil {
+			for _, opened := range files {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("failed to open %s: %w", s.path, err)
+		}
+		files = append(files, f)
+		readers = append(readers, io.LimitReader(f, s.size))
+	}
+	return &multiFileReader{Reader: io.MultiReader(readers...), files: files}, nil
+}
+
+// attach sets the body on httpReq so it can be sent and replayed; Content-Type is only set if missing
+func (b *requestBody) attach(httpReq *http.Request) error {
+	if len(b.segments) == 0 {
+		return nil
+	}
+
+	body, err := b.open()
+	if err != nil {
+		return err
+	}
+
+	httpReq.Body = body
+	httpReq.GetBody = b.open
+	httpReq.ContentLength = b.size()
+	if httpReq.ContentLength == 0 {
+		httpReq.Body.Close()
+		httpReq.Body = http.NoBody
+	}
+	if b.contentEncoding != "" {
+		// The compressed length is only known once the body has been sent
+		httpReq.ContentLength = -1
+	}
+
+	if b.contentType != "" {
+		current := httpReq.Header.Get("Content-Type")
+		// Multipart bodies must advertise the boundary we generated
+		if current == "" || strings.HasPrefix(b.contentType, "multipart/") {
+			httpReq.Header.Set("Content-Type", b.contentType)
+		}
+	}
+	if b.contentEncoding != "" {
+		httpReq.Header.Set("Content-Encoding", b.contentEncoding)
+	}
+	return nil
+}
+
+// multiFileReader reads the concatenated segments and closes every opened file
+type multiFileReader struct {
+	io.Reader
+	files []*os.File
+}
+
+// Close closes all files opened for the body
+func (r *multiFileReader) Close() error {
+	var firstErr error
+	for _, f := range r.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// expandBodyFields substitutes variables in the structured body fields
+func expandBodyFields(req *HTTPRequest, r *variableResolver) {
+	urlencoded := make([]KeyValue, len(req.URLEncoded))
+	for i, kv := range req.URLEncoded {
+		urlencoded[i] = KeyValue{Key: r.expand(kv.Key), Value: r.expand(kv.Value), Enabled: kv.Enabled}
+	}
+	req.URLEncoded = urlencoded
+
+	formData := make([]FormField, len(req.FormData))
+	for i, field := range req.FormData {
+		field.Key = r.expand(field.Key)
+		field.Value = r.expand(field.Value)
+		field.Src = r.expand(field.Src)
+		formData[i] = field
+	}
+	req.FormData = formData
+
+	req.BinaryFile = r.expand(req.BinaryFile)
+	req.GraphQL = expandGraphQL(req.GraphQL, r)
+}
+
+// buildRequestBody builds the body for the request's body mode
+func buildRequestBody(req HTTPRequest) (*requestBody, error) {
+	switch req.BodyMode {
+	case BodyModeNone:
+		return &requestBody{}, nil
+	case "", BodyModeRaw, BodyModeJSON:
+		body := &requestBody{summary: req.Body}
+		if req.Body != "" {
+			body.segments = []bodySegment{{data: []byte(req.Body), size: int64(len(req.Body))}}
+		}
+		if req.BodyMode == BodyModeJSON {
+			body.contentType = "application/json"
+		}
+		return body, nil
+	case BodyModeURLEncoded:
+		return buildURLEncodedBody(req.URLEncoded), nil
+	case BodyModeFormData:
+		return buildMultipartBody(req.FormData)
+	case BodyModeBinary:
+		return buildBinaryBody(req.BinaryFile)
+	case BodyModeGraphQL:
+		return buildGraphQLBody(req.GraphQL)
+	default:
+		return nil, fmt.Errorf("unsupported body mode %q", req.BodyMode)
+	}
+}
+
+// buildURLEncodedBody encodes the enabled rows in their original order
+func buildURLEncodedBody(rows []KeyValue) *requestBody {
+	var pairs []string
+	for _, kv := range rows {
+		if !kv.Enabled || kv.Key == "" {
+			continue
+		}
+		pairs = append(pairs, url.QueryEscape(kv.Key)+"="+url.QueryEscape(kv.Value))
+	}
+
+	encoded := strings.Join(pairs, "&")
+	return &requestBody{
+		segments:    []bodySegment{{data: []byte(encoded), size: int64(len(encoded))}},
+		contentType: "application/x-www-form-urlencoded",
+		summary:     encoded,
+	}
+}
+
+// buildMultipartBody lays out a multipart/form-data body, streaming file parts from disk
+func buildMultipartBody(fields []FormField) (*requestBody, error) {
+	body := &requestBody{}
+	var buf bytes.Buffer
+	var summary []string
+	writer := multipart.NewWriter(&buf)
+
+	// flush moves the buffered multipart framing into its own segment
+	flush := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		data := bytes.Clone(buf.Bytes())
+		body.segments = append(body.segments, bodySegment{data: data, size: int64(len(data))})
+		buf.Reset()
+	}
+
+	for _, field := range fields {
+		if !field.Enabled || field.Key == "" {
+			continue
+		}
+
+		if field.Type != FormFieldFile {
+			if err := writer.WriteField(field.Key, field.Value); err != nil {
+				return nil, err
+			}
+			summary = append(summary, field.Key+"="+field.Value)
+			continue
+		}
+
+		info, err := os.Stat(field.Src)
+		if err != nil {
+			return nil, fmt.Errorf("form field %s: %w", field.Key, err)
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("form field %s: %s is a directory", field.Key, field.Src)
+		}
+
+		contentType := field.ContentType
+		if contentType == "" {
+			contentType = contentTypeForFile(field.Src)
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+			"name":     field.Key,
+			"filename": filepath.Base(field.Src),
+		}))
+		header.Set("Content-Type", contentType)
+		if _, err := writer.CreatePart(header); err != nil {
+			return nil, err
+		}
+
+		flush()
+		body.segments = append(body.segments, bodySegment{path: field.Src, size: info.Size()})
+		summary = append(summary, fmt.Sprintf("%s=@%s (%d bytes)", field.Key, field.Src, info.Size()))
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	body.contentType = writer.FormDataContentType()
+	body.summary = strings.Join(summary, "\n")
+	return body, nil
+}
+
+// buildBinaryBody streams a single file as the body
+func buildBinaryBody(path string) (*requestBody, error) {
+	if path == "" {
+		return nil, fmt.Errorf("binary body requires a file")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("binary body: %w", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("binary body: %s is a directory", path)
+	}
+
+	return &requestBody{
+		segments:    []bodySegment{{path: path, size: info.Size()}},
+		contentType: contentTypeForFile(path),
+		summary:     fmt.Sprintf("@%s (%d bytes)", path, info.Size()),
+	}, nil
+}
+
+// contentTypeForFile guesses a file's content type from its extension
+func contentTypeForFile(path string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(path)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// cleanJSONBody validates a JSON body and re-marshals it compactly
+func cleanJSONBody(body string) (string, error) {
+	var jsonTest interface{}
+	if err := json.Unmarshal([]byte(body), &jsonTest); err != nil {
+		return "", fmt.Errorf("invalid JSON body: %w", err)
+	}
+	cleanJSON, err := json.Marshal(jsonTest)
+	if err != nil {
+		return "", fmt.Errorf("failed to clean JSON: %w", err)
+	}
+	return string(cleanJSON), nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HTTP versions for RequestSettings.HTTPVersion
+const (
+	HTTPVersionAuto = "auto"
+	HTTPVersion1    = "http1"
+	HTTPVersion2    = "http2"
+)
+
+// Defaults used when neither the request nor its collection sets a value
+const (
+	defaultTimeout   = 30 * time.Second
+	defaultRedirects = 10
+)
+
+// RequestSettings controls how a request is sent. Unset fields fall back to the
+// collection's settings and then to the defaults.
+type RequestSettings struct {
+	TimeoutMs            int    `json:"timeoutMs,omitempty"`            // 0 uses the 30s default
+	FollowRedirects      *bool  `json:"followRedirects,omitempty"`      // defaults to true
+	MaxRedirects         int    `json:"maxRedirects,omitempty"`         // defaults to 10
+	InsecureSkipVerify   *bool  `json:"insecureSkipVerify,omitempty"`   // skip TLS certificate verification
+	HTTPVersion          string `json:"httpVersion,omitempty"`          // auto (default), http1 or http2
+	DisableDecompression *bool  `json:"disableDecompression,omitempty"` // return compressed bodies as received
+}
+
+// sendSettings are the resolved settings used for one send
+type sendSettings struct {
+	timeout              time.Duration
+	followRedirects      bool
+	maxRedirects         int
+	insecureSkipVerify   bool
+	httpVersion          string
+	disableDecompression bool
+	proxy                proxyConfig
+}
+
+// resolveSettings layers the request's settings over the collection's and the defaults
+func resolveSettings(layers ...*RequestSettings) (sendSettings, error) {
+	resolved := sendSettings{
+		timeout:         defaultTimeout,
+		followRedirects: true,
+		maxRedirects:    defaultRedirects,
+		httpVersion:     HTTPVersionAuto,
+	}
+
+	for _, s := range layers {
+		if s == nil {
+			continue
+		}
+		if s.TimeoutMs > 0 {
+			resolved.timeout = time.Duration(s.TimeoutMs) * time.Millisecond
+		}
+		if s.FollowRedirects != nil {
+			resolved.followRedirects = *s.FollowRedirects
+		}
+		if s.MaxRedirects > 0 {
+			resolved.maxRedirects = s.MaxRedirects
+		}
+		if s.InsecureSkipVerify != nil {
+			resolved.insecureSkipVerify = *s.InsecureSkipVerify
+		}
+		if s.HTTPVersion != "" {
+			resolved.httpVersion = s.HTTPVersion
+		}
+		if s.DisableDecompression != nil {
+			resolved.disableDecompression = *s.DisableDecompression
+		}
+	}
+
+	switch resolved.httpVersion {
+	case HTTPVersionAuto, HTTPVersion1, HTTPVersion2:
+	default:
+		return sendSettings{}, fmt.Errorf("unsupported HTTP version %q", resolved.httpVersion)
+	}
+	return resolved, nil
+}
+
+// requestSettings resolves the settings for a request, falling back to its collection's defaults.
+// The proxy comes from the collection when it sets a mode, otherwise from the application settings.
+func (h *HTTPService) requestSettings(ctx context.Context, req HTTPRequest) (sendSettings, error) {
+	var collectionSettings *RequestSettings
+	var proxySettings *ProxySettings
+	if req.CollectionID != "" && h.collectionService != nil {
+		if collection, err := h.collectionService.GetCollection(ctx, req.CollectionID); err == nil {
+			collectionSettings = collection.Settings
+			proxySettings = collection.Proxy
+		}
+	}
+
+	settings, err := resolveSettings(collectionSettings, req.Settings)
+	if err != nil {
+		return sendSettings{}, err
+	}
+
+	if proxySettings == nil || proxySettings.Mode == "" {
+		app, err := h.settingsService.load()
+		if err != nil {
+			return sendSettings{}, err
+		}
+		proxySettings = &app.Proxy
+	}
+	settings.proxy, err = resolveProxy(proxySettings, h.settingsService.secrets)
+	if err != nil {
+		return sendSettings{}, fmt.Errorf("invalid proxy settings: %w", err)
+	}
+	return settings, nil
+}
+
+// transportKey identifies the transport options that change how connections are made
+type transportKey struct {
+	insecureSkipVerify bool
+	httpVersion        string
+	clientCertID       string
+	caIDs              string
+	proxy              string
+}
+
+// transportPool shares one transport per option combination so connections are reused across sends
+type transportPool struct {
+	mu         sync.Mutex
+	transports map[transportKey]*http.Transport
+}
+
+// newTransportPool creates an empty transport pool
+func newTransportPool() *transportPool {
+	return &transportPool{transports: make(map[transportKey]*http.Transport)}
+}
+
+// get returns the transport for key, creating it on first use with the proxy and configureTLS applied
+func (p *transportPool) get(key transportKey, proxy proxyConfig, configureTLS func(*tls.Config) error) (*http.Transport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.transports[key]; ok {
+		return t, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: key.insecureSkipVerify}
+	if err := configureTLS(tlsConfig); err != nil {
+		return nil, err
+	}
+
+	t := &http.Transport{
+		Proxy: proxy.proxyFunc(),
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		// Responses are decoded by SendRequest so the wire size can be reported
+		DisableCompression: true,
+		TLSClientConfig:    tlsConfig,
+	}
+
+	switch key.httpVersion {
+	case HTTPVersion1:
+		// A non-nil empty map turns off HTTP/2 negotiation
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case HTTPVersion2:
+		t.ForceAttemptHTTP2 = true
+		// Fail the handshake when the server does not pick h2 so nothing is sent over HTTP/1.1
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if state.NegotiatedProtocol != "h2" {
+				return fmt.Errorf("HTTP/2 was required but the server does not support it")
+			}
+			return nil
+		}
+	default:
+		// A custom TLS config disables HTTP/2 unless it is asked for explicitly
+		t.ForceAttemptHTTP2 = true
+	}
+
+	p.transports[key] = t
+	return t, nil
+}
+
+// clear drops every transport so new connections pick up changed TLS material
+func (p *transportPool) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, t := range p.transports {
+		t.CloseIdleConnections()
+		delete(p.transports, key)
+	}
+}
+
+// clientFor builds a client for the settings on top of the shared transports, recording
+// followed redirects and storing cookies in jar. The target's TLS material is loaded up front
+// so certificate errors are reported before sending.
+func (h *HTTPService) clientFor(settings sendSettings, target *url.URL, redirects *redirectRecorder, jar http.CookieJar) (*http.Client, error) {
+	if _, err := h.transportFor(settings, target); err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: &hostTransport{h: h, settings: settings},
+		Timeout:   settings.timeout,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !settings.followRedirects {
+				return http.ErrUseLastResponse
+			}
+			redirects.record(req, via)
+			if len(via) > settings.maxRedirects {
+				// Return the redirect that was not followed instead of failing the send
+				redirects.limitReached = true
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}, nil
+}
+
+// transportFor returns the shared transport carrying the client certificate and CA bundles
+// registered for the host and port of u
+func (h *HTTPService) transportFor(settings sendSettings, u *url.URL) (*http.Transport, error) {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	selection, err := h.certificates.forHost(u.Hostname(), port)
+	if err != nil {
+		return nil, err
+	}
+	clientCertID, caIDs := selection.ids()
+
+	return h.transports.get(transportKey{
+		insecureSkipVerify: settings.insecureSkipVerify,
+		httpVersion:        settings.httpVersion,
+		clientCertID:       clientCertID,
+		caIDs:              caIDs,
+		proxy:              settings.proxy.key(),
+	}, settings.proxy, func(config *tls.Config) error {
+		return h.certificates.configure(config, selection)
+	})
+}
+
+// hostTransport sends each request, including every redirect hop, through the transport for
+// its own host so certificates registered for one host are never presented to another
+type hostTransport struct {
+	h        *HTTPService
+	settings sendSettings
+}
+
+// RoundTrip sends req on the transport selected for its URL
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Redirects can lead to plain http:// URLs
+	if err := checkHTTPVersion(t.settings, req.URL); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	transport, err := t.h.transportFor(t.settings, req.URL)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("failed to configure TLS for %s: %w", req.URL.Host, err)
+	}
+	return transport.RoundTrip(req)
+}
+
+// checkHTTPVersion reports an error when HTTP/2 is required for a URL it cannot be used with.
+// HTTP/2 is only negotiated over TLS, so plain http:// URLs are refused before sending.
+func checkHTTPVersion(settings sendSettings, u *url.URL) error {
+	if settings.httpVersion == HTTPVersion2 && u.Scheme != "https" {
+		return fmt.Errorf("HTTP/2 was required but %s does not use https", u.Redacted())
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// maxInlineBody is the largest body returned in full; larger bodies are spooled to disk
+	maxInlineBody = 1 << 20
+	// bodyPreviewSize is how much of a spooled body is returned as a preview
+	bodyPreviewSize = 64 << 10
+	// maxStoredBodies is how many recent response bodies are kept for SaveResponseBody
+	maxStoredBodies = 50
+)
+
+// BodyEncodingBase64 marks a response body that is base64 encoded because it is binary
+const BodyEncodingBase64 = "base64"
+
+// responseBody is a read response body: the inline data or preview and the full size.
+// data keeps the original bytes; decoded is the text converted from charset to UTF-8.
+type responseBody struct {
+	data      []byte
+	decoded   string
+	size      int64
+	truncated bool
+	mimeType  string
+	charset   string
+	binary    bool
+}
+
+// text returns the body as returned to the UI, base64 encoding binary content
+func (b *responseBody) text() (string, string) {
+	if b.binary {
+		return base64.StdEncoding.EncodeToString(b.data), BodyEncodingBase64
+	}
+	return b.decoded, ""
+}
+
+// storedBody is a kept response body, held in memory or in a spool file
+type storedBody struct {
+	data []byte
+	path string
+}
+
+// responseBodyStore keeps the most recent response bodies so they can be saved to disk.
+// Large bodies are spooled to a temp directory owned by this process.
+type responseBodyStore struct {
+	mu     sync.Mutex
+	dir    string
+	order  []string
+	bodies map[string]*storedBody
+}
+
+// newResponseBodyStore creates an empty store; its spool directory is created on first use
+func newResponseBodyStore() *responseBodyStore {
+	return &responseBodyStore{bodies: make(map[string]*storedBody)}
+}
+
+// spoolDir returns the store's spool directory, creating it on first use
+func (s *responseBodyStore) spoolDir() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		dir, err := os.MkdirTemp("", "captain-api-bodies-")
+		if err != nil {
+			return "", err
+		}
+		s.dir = dir
+	}
+	return s.dir, nil
+}
+
+// close drops every stored body and removes the spool directory
+func (s *responseBodyStore) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.order = nil
+	s.bodies = make(map[string]*storedBody)
+	if s.dir == "" {
+		return nil
+	}
+	dir := s.dir
+	s.dir = ""
+	return os.RemoveAll(dir)
+}
+
+// read consumes r, keeping small bodies in memory and spooling larger ones to a temp file.
+// Bodies that are still content-encoded are always treated as binary.
+func (s *responseBodyStore) read(id string, r io.Reader, contentType string, encoded bool) (*responseBody, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxInlineBody+1))
+	if err != nil {
+		return nil, err
+	}
+
+	body := &responseBody{data: data, size: int64(len(data))}
+	stored := &storedBody{data: data}
+
+	if len(data) > maxInlineBody {
+		dir, err := s.spoolDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create spool directory: %w", err)
+		}
+		file, err := os.CreateTemp(dir, "body-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create spool file: %w", err)
+		}
+		n, err := io.Copy(file, io.MultiReader(bytes.NewReader(data), r))
+		file.Close()
+		if err != nil {
+			os.Remove(file.Name())
+			return nil, err
+		}
+
+		body.data = data[:bodyPreviewSize]
+		body.size = n
+		body.truncated = true
+		stored = &storedBody{path: file.Name()}
+	}
+
+	body.mimeType, body.binary = detectBodyType(contentType, body.data, body.truncated)
+	body.binary = body.binary || encoded && len(body.data) > 0
+	if !body.binary {
+		body.decoded, body.charset = decodeBody(body.data, contentType, body.mimeType, body.truncated)
+	}
+	s.keep(id, stored)
+	return body, nil
+}
+
+// keep stores a body under id, discarding the oldest bodies beyond maxStoredBodies
+func (s *responseBodyStore) keep(id string, body *storedBody) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.bodies[id]; ok {
+		old.remove()
+	} else {
+		s.order = append(s.order, id)
+	}
+	s.bodies[id] = body
+
+	for len(s.order) > maxStoredBodies {
+		if old, ok := s.bodies[s.order[0]]; ok {
+			old.remove()
+			delete(s.bodies, s.order[0])
+		}
+		s.order = s.order[1:]
+	}
+}
+
+// open returns a reader over the full body stored under id
+func (s *responseBodyStore) open(id string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, ok := s.bodies[id]
+	if !ok {
+		return nil, fmt.Errorf("response body %s is no longer available", id)
+	}
+	if body.path == "" {
+		return io.NopCloser(bytes.NewReader(body.data)), nil
+	}
+	return os.Open(body.path)
+}
+
+// remove deletes the spool file backing a body, if any
+func (b *storedBody) remove() {
+	if b.path != "" {
+		os.Remove(b.path)
+	}
+}
+
+// OnShutdown removes the spooled response bodies when the application exits
+func (h *HTTPService) OnShutdown() error {
+	return h.responseBodies.close()
+}
+
+// SaveResponseBody writes the full decoded body of the response with the given request ID to path.
+// Content-Encoding such as gzip has been removed unless the request disabled decompression;
+// the charset is not converted.
+func (h *HTTPService) SaveResponseBody(ctx context.Context, id string, path string) error {
+	body, err := h.responseBodies.open(id)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// fullResponseBody returns the complete original bytes of a response body
+func (h *HTTPService) fullResponseBody(resp *HTTPResponse) ([]byte, error) {
+	body, err := h.responseBodies.open(resp.RequestID)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// detectBodyType returns the body's MIME type, from the Content-Type header or sniffed from
+// the content, and whether it is binary
+func detectBodyType(contentType string, data []byte, partial bool) (string, bool) {
+	mimeType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mimeType == "" {
+		mimeType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if len(data) == 0 || isTextMediaType(mimeType) {
+		return mimeType, false
+	}
+	return mimeType, !looksLikeText(data, partial)
+}
+
+// isTextMediaType reports whether a media type is always textual
+func isTextMediaType(mediaType string) bool {
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-www-form-urlencoded", "application/graphql", "application/x-ndjson":
+		return true
+	}
+	return false
+}
+
+// looksLikeText reports whether data is valid UTF-8 without NUL bytes. For a partial body,
+// a rune cut off at the end is ignored.
+func looksLikeText(data []byte, partial bool) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return false
+	}
+	for i := 0; partial && i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+		data = data[:len(data)-1]
+	}
+	return utf8.Valid(data)
+}
+
+// xmlEncodingPattern matches the encoding in an XML declaration
+var xmlEncodingPattern = regexp.MustCompile(`^\s*<\?xml[^>]*\sencoding\s*=\s*["']([A-Za-z0-9._-]+)["']`)
+
+// decodeBody converts a text body to UTF-8 and returns the charset it was decoded from.
+// The charset comes from a BOM, the Content-Type charset parameter, an XML declaration or
+// HTML meta tag, falling back to UTF-8 when the content is valid UTF-8 and windows-1252 otherwise.
+func decodeBody(data []byte, contentType string, mimeType string, partial bool) (string, string) {
+	enc, name, certain := charset.DetermineEncoding(data, contentType)
+	if !certain && strings.HasSuffix(mimeType, "xml") {
+		if m := xmlEncodingPattern.FindSubmatch(data); m != nil {
+			if e, n := charset.Lookup(string(m[1])); e != nil {
+				enc, name, certain = e, n, true
+			}
+		}
+	}
+	// Without a declared charset, DetermineEncoding only checks the first 1KB for UTF-8 and
+	// reports windows-1252 for plain ASCII, so check the whole body before falling back
+	if !certain && name == "windows-1252" && looksLikeText(data, partial) {
+		name = "utf-8"
+	}
+	if name == "utf-8" {
+		return strings.TrimPrefix(string(data), "\ufeff"), name
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data), name
+	}
+	return strings.TrimPrefix(string(decoded), "\ufeff"), name
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// secretPrefix marks a variable value that has been encrypted at rest
+const secretPrefix = "enc:v1:"
+
+// secretMask replaces secret values in logs
+const secretMask = "******"
+
+// secretPassphraseEnv names the environment variable that, when set, is used to derive the encryption key
+const secretPassphraseEnv = "CAPTAIN_API_PASSPHRASE"
+
+// secretKeeper encrypts and decrypts secret variable values with a locally derived AES-256-GCM key.
+// The key is derived from the CAPTAIN_API_PASSPHRASE environment variable when set, otherwise it is
+// read from (or generated into) a key file next to the collections directory.
+type secretKeeper struct {
+	mu       sync.Mutex
+	keyPath  string
+	saltPath string
+	aead     cipher.AEAD
+}
+
+// newSecretKeeper creates a secret keeper storing its key material in dir
+func newSecretKeeper(dir string) *secretKeeper {
+	return &secretKeeper{
+		keyPath:  filepath.Join(dir, "secret.key"),
+		saltPath: filepath.Join(dir, "secret.salt"),
+	}
+}
+
+// encrypt returns the at-rest form of a secret value; already encrypted values are returned unchanged
+func (s *secretKeeper) encrypt(plaintext string) (string, error) {
+	if plaintext == "" || isEncryptedSecret(plaintext) {
+		return plaintext, nil
+	}
+
+	aead, err := s.cipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt returns the plaintext of an encrypted value; values that are not encrypted are returned unchanged
+func (s *secretKeeper) decrypt(value string) (string, error) {
+	if !isEncryptedSecret(value) {
+		return value, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %w", err)
+	}
+
+	aead, err := s.cipher()
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("invalid encrypted value: too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret (wrong passphrase or key file?): %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// sealVariables encrypts the values of secret variables. Other values are stored as is, unless
+// they are one of the stored ciphertexts of a variable that has since been unmarked secret.
+func (s *secretKeeper) sealVariables(variables []Variable, stored map[string]bool) error {
+	for i := range variables {
+		var err error
+		if variables[i].Secret {
+			variables[i].Value, err = s.encrypt(variables[i].Value)
+		} else if stored[variables[i].Value] {
+			variables[i].Value, err = s.decrypt(variables[i].Value)
+		}
+		if err != nil {
+			return fmt.Errorf("variable %s: %w", variables[i].Key, err)
+		}
+	}
+	return nil
+}
+
+// storedSecrets returns the encrypted values of the secret variables in each list
+func storedSecrets(lists ...[]Variable) map[string]bool {
+	stored := make(map[string]bool)
+	for _, variables := range lists {
+		for _, v := range variables {
+			if v.Secret && isEncryptedSecret(v.Value) {
+				stored[v.Value] = true
+			}
+		}
+	}
+	return stored
+}
+
+// authSecrets returns the credential fields of auth that are encrypted at rest
+func authSecrets(auth *RequestAuth) []*string {
+	var fields []*string
+	if auth.Basic != nil {
+		fields = append(fields, &auth.Basic.Password)
+	}
+	if auth.Bearer != nil {
+		fields = append(fields, &auth.Bearer.Token)
+	}
+	if auth.APIKey != nil {
+		fields = append(fields, &auth.APIKey.Value)
+	}
+	if auth.Digest != nil {
+		fields = append(fields, &auth.Digest.Password)
+	}
+	if auth.OAuth2 != nil {
+		fields = append(fields, &auth.OAuth2.ClientSecret, &auth.OAuth2.Password, &auth.OAuth2.RefreshToken)
+	}
+	if auth.AWSSigV4 != nil {
+		fields = append(fields, &auth.AWSSigV4.SecretAccessKey, &auth.AWSSigV4.SessionToken)
+	}
+	if auth.HMAC != nil {
+		fields = append(fields, &auth.HMAC.Secret)
+	}
+	if auth.JWT != nil {
+		fields = append(fields, &auth.JWT.Secret, &auth.JWT.PrivateKey)
+	}
+	return fields
+}
+
+// sealAuth encrypts the credentials of auth in place
+func (s *secretKeeper) sealAuth(auth *RequestAuth) error {
+	if auth == nil {
+		return nil
+	}
+	for _, field := range authSecrets(auth) {
+		sealed, err := s.encrypt(*field)
+		if err != nil {
+			return fmt.Errorf("%s auth: %w", auth.Type, err)
+		}
+		*field = sealed
+	}
+	return nil
+}
+
+// openAuth returns a copy of auth with its credentials decrypted
+func (s *secretKeeper) openAuth(auth *RequestAuth) (*RequestAuth, error) {
+	if auth == nil {
+		return nil, nil
+	}
+
+	opened := *auth
+	if auth.Basic != nil {
+		basic := *auth.Basic
+		opened.Basic = &basic
+	}
+	if auth.Bearer != nil {
+		bearer := *auth.Bearer
+		opened.Bearer = &bearer
+	}
+	if auth.APIKey != nil {
+		apiKey := *auth.APIKey
+		opened.APIKey = &apiKey
+	}
+	if auth.Digest != nil {
+		digest := *auth.Digest
+		opened.Digest = &digest
+	}
+	if auth.OAuth2 != nil {
+		oauth2 := *auth.OAuth2
+		opened.OAuth2 = &oauth2
+	}
+	if auth.AWSSigV4 != nil {
+		aws := *auth.AWSSigV4
+		opened.AWSSigV4 = &aws
+	}
+	if auth.HMAC != nil {
+		hmac := *auth.HMAC
+		opened.HMAC = &hmac
+	}
+	if auth.JWT != nil {
+		jwt := *auth.JWT
+		opened.JWT = &jwt
+	}
+
+	for _, field := range authSecrets(&opened) {
+		plaintext, err := s.decrypt(*field)
+		if err != nil {
+			return nil, fmt.Errorf("%s auth: %w", auth.Type, err)
+		}
+		*field = plaintext
+	}
+	return &opened, nil
+}
+
+// cipher lazily loads the key and builds the AEAD
+func (s *secretKeeper) cipher() (cipher.AEAD, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.aead != nil {
+		return s.aead, nil
+	}
+
+	key, err := s.loadKey()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	s.aead = aead
+	return aead, nil
+}
+
+// loadKey derives the key from the passphrase if one is configured, otherwise reads the key file
+func (s *secretKeeper) loadKey() ([]byte, error) {
+	if passphrase := os.Getenv(secretPassphraseEnv); passphrase != "" {
+		salt, err := readOrCreateRandomFile(s.saltPath, 16)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load secret salt: %w", err)
+		}
+
+		key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive secret key: %w", err)
+		}
+		return key, nil
+	}
+
+	key, err := readOrCreateRandomFile(s.keyPath, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secret key: %w", err)
+	}
+	return key, nil
+}
+
+// readOrCreateRandomFile returns the contents of path, creating it with size random bytes if it does not exist.
+// Creation is exclusive, so when several keepers race the loser reads the winner's file instead of replacing it.
+func readOrCreateRandomFile(path string, size int) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if len(data) == size {
+				return data, nil
+			}
+			// A concurrent creator may not have finished writing yet
+			if attempt < 10 {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return nil, fmt.Errorf("%s has unexpected length %d", path, len(data))
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		data, err = createRandomFile(path, size)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return data, err
+	}
+}
+
+// createRandomFile writes size random bytes to path, failing with fs.ErrExist if it already exists
+func createRandomFile(path string, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return data, nil
+}
+
+// isEncryptedSecret reports whether value is in the encrypted at-rest form
+func isEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// maskSecrets replaces every occurrence of the given secret values in s, raw and in each URL
+// encoding a request may use: query escaping with "+" or "%20" for spaces, and path escaping
+func maskSecrets(s string, secrets []string) string {
+	if s == "" || len(secrets) == 0 {
+		return s
+	}
+
+	var forms []string
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		forms = append(forms, secret, url.QueryEscape(secret), encodeQueryPart(secret), url.PathEscape(secret))
+	}
+
+	// Replace longer forms first so a secret contained in another is not partially masked
+	sort.Slice(forms, func(i, j int) bool {
+		return len(forms[i]) > len(forms[j])
+	})
+
+	for _, form := range forms {
+		s = strings.ReplaceAll(s, form, secretMask)
+	}
+	return s
+}
+
+// maskGeneratedSecrets returns a copy of generated variables with secret values masked
+func maskGeneratedSecrets(generated []GeneratedVariable, secrets []string) []GeneratedVariable {
+	if generated == nil {
+		return nil
+	}
+
+	masked := make([]GeneratedVariable, len(generated))
+	for i, g := range generated {
+		masked[i] = GeneratedVariable{Name: g.Name, Value: maskSecrets(g.Value, secrets)}
+	}
+	return masked
+}
+
+// maskHeaderSecrets returns a copy of headers with secret values masked
+func maskHeaderSecrets(headers Headers, secrets []string) Headers {
+	masked := copyHeaders(headers)
+	for i, header := range masked {
+		masked[i].Value = maskSecrets(header.Value, secrets)
+	}
+	return masked
+}
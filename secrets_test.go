@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMaskSecretsEncodings(t *testing.T) {
+	secret := "open sesame/&=!"
+	for _, form := range []string{
+		secret,
+		url.QueryEscape(secret),
+		encodeQueryPart(secret),
+		url.PathEscape(secret),
+	} {
+		s := "https://api.example.com/items?token=" + form + "&page=1"
+		if got := maskSecrets(s, []string{secret}); strings.Contains(got, "sesame") {
+			t.Errorf("maskSecrets(%q) = %q, secret not masked", s, got)
+		}
+	}
+}
+
+func TestReadOrCreateRandomFileConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "secret.key")
+
+	results := make([][]byte, 8)
+	errs := make([]error, len(results))
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = readOrCreateRandomFile(path, 32)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatalf("caller %d: %v", i, errs[i])
+		}
+		if !bytes.Equal(results[i], results[0]) {
+			t.Fatalf("caller %d got a different key than caller 0", i)
+		}
+	}
+}
+
+func TestSealVariablesOnlyTouchesSecrets(t *testing.T) {
+	ctx := context.Background()
+	c := newTestHTTPService(t).collectionService
+
+	// A plain value that happens to look encrypted must be stored and read back unchanged
+	lookalike := secretPrefix + "not-a-ciphertext"
+	if err := c.SaveGlobalVariables(ctx, []Variable{
+		{Key: "plain", Value: lookalike},
+		{Key: "token", Value: "s3cret", Secret: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := c.GetGlobalVariables(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved[0].Value != lookalike {
+		t.Errorf("plain value = %q, want %q", saved[0].Value, lookalike)
+	}
+	if saved[1].Value == "s3cret" || !isEncryptedSecret(saved[1].Value) {
+		t.Fatalf("secret value stored as %q, want it encrypted", saved[1].Value)
+	}
+
+	// Unmarking a secret stores its plaintext again
+	saved[1].Secret = false
+	if err := c.SaveGlobalVariables(ctx, saved); err != nil {
+		t.Fatal(err)
+	}
+	saved, err = c.GetGlobalVariables(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved[0].Value != lookalike || saved[1].Value != "s3cret" {
+		t.Errorf("values after unmarking = %q, %q, want %q, s3cret", saved[0].Value, saved[1].Value, lookalike)
+	}
+}
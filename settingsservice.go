@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// AppSettings holds application-wide preferences
+type AppSettings struct {
+	Proxy ProxySettings `json:"proxy"`
+	// LogRawExchange stores the raw request and response in each request log entry
+	LogRawExchange bool `json:"logRawExchange"`
+}
+
+// SettingsService manages application settings stored in ~/.captain-api/settings.json
+type SettingsService struct {
+	mu           sync.Mutex
+	settingsPath string
+	secrets      *secretKeeper
+}
+
+// NewSettingsService creates a new settings service
+func NewSettingsService() *SettingsService {
+	// Get user's home directory
+	homeDir, _ := os.UserHomeDir()
+	dir := filepath.Join(homeDir, ".captain-api")
+
+	// Create directory if it doesn't exist
+	os.MkdirAll(dir, 0755)
+
+	return &SettingsService{
+		settingsPath: filepath.Join(dir, "settings.json"),
+		secrets:      newSecretKeeper(dir),
+	}
+}
+
+// GetSettings returns the application settings with secrets masked
+func (s *SettingsService) GetSettings(ctx context.Context) (*AppSettings, error) {
+	settings, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if settings.Proxy.Password != "" {
+		settings.Proxy.Password = secretMask
+	}
+	return settings, nil
+}
+
+// SaveSettings replaces the application settings. A masked proxy password keeps the stored one.
+func (s *SettingsService) SaveSettings(ctx context.Context, settings AppSettings) error {
+	if err := settings.Proxy.validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if settings.Proxy.Password == secretMask {
+		existing, err := s.load()
+		if err != nil {
+			return err
+		}
+		settings.Proxy.Password = existing.Proxy.Password
+	}
+
+	password, err := s.secrets.encrypt(settings.Proxy.Password)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt proxy password: %w", err)
+	}
+	settings.Proxy.Password = password
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal settings: %w", err)
+	}
+	return os.WriteFile(s.settingsPath, data, 0600)
+}
+
+// load reads the settings file, falling back to the defaults when it does not exist
+func (s *SettingsService) load() (*AppSettings, error) {
+	settings := &AppSettings{
+		Proxy: ProxySettings{Mode: ProxyModeSystem},
+	}
+
+	data, err := os.ReadFile(s.settingsPath)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings: %w", err)
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		return nil, fmt.Errorf("failed to parse settings: %w", err)
+	}
+	return settings, nil
+}
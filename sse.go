@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Events emitted to the frontend while a Server-Sent Events stream runs
+const (
+	SSEEventName  = "sse-event"
+	SSEStatusName = "sse-status"
+)
+
+// Stream states reported in SSEStatus.State
+const (
+	SSEStateOpen         = "open"
+	SSEStateReconnecting = "reconnecting"
+	SSEStateClosed       = "closed"
+)
+
+// defaultSSERetry is the reconnection delay used until the server sends a retry field
+const defaultSSERetry = 3 * time.Second
+
+// SSEEvent is one event received on a stream
+type SSEEvent struct {
+	StreamID   string    `json:"streamId"`
+	ID         string    `json:"id,omitempty"`
+	Event      string    `json:"event"`
+	Data       string    `json:"data"`
+	Retry      int       `json:"retry,omitempty"` // reconnection delay in milliseconds, when the event set one
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// SSEStatus reports a change in a stream's connection state
+type SSEStatus struct {
+	StreamID string `json:"streamId"`
+	State    string `json:"state"`
+	Error    string `json:"error,omitempty"`
+}
+
+// sseStream is the reconnection state of a running stream
+type sseStream struct {
+	id          string
+	req         HTTPRequest
+	lastEventID string
+	retry       time.Duration
+}
+
+// StreamSSE connects to a text/event-stream endpoint and emits each event to the frontend as
+// sse-event, with connection changes as sse-status. The connection is made before returning
+// so request errors reach the caller; dropped connections are resumed with Last-Event-ID.
+// It returns the stream ID used by StopSSE.
+func (h *HTTPService) StreamSSE(ctx context.Context, req HTTPRequest) (string, error) {
+	if req.RequestID == "" {
+		req.RequestID = uuid.New().String()
+	}
+
+	// The stream outlives this call, so it gets its own context
+	streamCtx, cancel := context.WithCancel(context.Background())
+	err := h.inFlight.add(InFlightRequest{
+		ID:           req.RequestID,
+		Method:       req.Method,
+		URL:          req.URL,
+		CollectionID: req.CollectionID,
+		StartedAt:    time.Now(),
+	}, cancel)
+	if err != nil {
+		cancel()
+		return "", err
+	}
+
+	stream := &sseStream{id: req.RequestID, req: req, retry: defaultSSERetry}
+	resp, _, err := h.openSSE(streamCtx, stream)
+	if err != nil {
+		h.inFlight.remove(stream.id)
+		cancel()
+		return "", err
+	}
+
+	go h.runSSE(streamCtx, cancel, stream, resp)
+	return stream.id, nil
+}
+
+// StopSSE closes the stream with the given ID
+func (h *HTTPService) StopSSE(ctx context.Context, id string) error {
+	if !h.inFlight.cancel(id) {
+		return fmt.Errorf("stream %s is not running", id)
+	}
+	return nil
+}
+
+// openSSE sends the stream request. fatal is set when the server answered in a way that
+// means the stream must not be retried.
+func (h *HTTPService) openSSE(ctx context.Context, stream *sseStream) (resp *http.Response, fatal bool, err error) {
+	req := stream.req
+	req.Headers = append(Headers{}, req.Headers...)
+	if !req.Headers.Has("Accept") {
+		req.Headers = append(req.Headers, Header{Key: "Accept", Value: "text/event-stream", Enabled: true})
+	}
+	if !req.Headers.Has("Cache-Control") {
+		req.Headers = append(req.Headers, Header{Key: "Cache-Control", Value: "no-cache", Enabled: true})
+	}
+	if stream.lastEventID != "" {
+		req.Headers = append(req.Headers, Header{Key: "Last-Event-ID", Value: stream.lastEventID, Enabled: true})
+	}
+
+	prepared, err := h.prepareRequest(ctx, req)
+	if err != nil {
+		return nil, true, err
+	}
+	httpReq := prepared.httpReq
+
+	// The body never ends, so the request timeout must not apply
+	settings := prepared.settings
+	settings.timeout = 0
+	redirects := newRedirectRecorder()
+	client, err := h.clientFor(settings, httpReq.URL, redirects, h.cookies.jar(prepared.tokenScope))
+	if err != nil {
+		if httpReq.Body != nil {
+			httpReq.Body.Close()
+		}
+		return nil, true, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+
+	resp, _, err = h.doWithAuth(client, httpReq, prepared.auth, prepared.tokenScope, settings, redirects)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, true, fmt.Errorf("server responded with %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/event-stream" {
+		resp.Body.Close()
+		return nil, true, fmt.Errorf("server responded with %q instead of text/event-stream", resp.Header.Get("Content-Type"))
+	}
+	return resp, false, nil
+}
+
+// runSSE reads events until the stream is stopped, reconnecting after the retry delay when
+// the connection drops. A line over the size limit closes the stream instead.
+func (h *HTTPService) runSSE(ctx context.Context, cancel context.CancelFunc, stream *sseStream, resp *http.Response) {
+	defer cancel()
+	defer h.inFlight.remove(stream.id)
+
+	for {
+		h.emitSSEStatus(stream.id, SSEStateOpen, nil)
+		body, _ := decodeContent(resp.Body, resp.Header.Get("Content-Encoding"))
+		err := h.readSSE(stream, body)
+		body.Close()
+		resp.Body.Close()
+
+		// Reconnecting would only resend the same oversized line
+		if errors.Is(err, bufio.ErrTooLong) && ctx.Err() == nil {
+			h.emitSSEStatus(stream.id, SSEStateClosed, err)
+			return
+		}
+
+		for {
+			if ctx.Err() != nil {
+				h.emitSSEStatus(stream.id, SSEStateClosed, nil)
+				return
+			}
+			if err == nil {
+				err = fmt.Errorf("connection closed by server")
+			}
+			h.emitSSEStatus(stream.id, SSEStateReconnecting, err)
+
+			select {
+			case <-ctx.Done():
+				h.emitSSEStatus(stream.id, SSEStateClosed, nil)
+				return
+			case <-time.After(stream.retry):
+			}
+
+			var fatal bool
+			resp, fatal, err = h.openSSE(ctx, stream)
+			if err == nil {
+				break
+			}
+			if fatal && ctx.Err() == nil {
+				h.emitSSEStatus(stream.id, SSEStateClosed, err)
+				return
+			}
+		}
+	}
+}
+
+// readSSE parses the event stream, emitting each complete event, until the body ends.
+// A line longer than maxInlineBody stops it with an error wrapping bufio.ErrTooLong.
+func (h *HTTPService) readSSE(stream *sseStream, body io.Reader) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), maxInlineBody)
+	scanner.Split(scanSSELines)
+
+	var event SSEEvent
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// A blank line dispatches the event collected so far
+		if line == "" {
+			if data.Len() > 0 {
+				event.StreamID = stream.id
+				event.ID = stream.lastEventID
+				event.Data = strings.TrimSuffix(data.String(), "\n")
+				event.ReceivedAt = time.Now()
+				if event.Event == "" {
+					event.Event = "message"
+				}
+				h.emitSSE(SSEEventName, event)
+			}
+			event = SSEEvent{}
+			data.Reset()
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event.Event = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				stream.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				stream.retry = time.Duration(ms) * time.Millisecond
+				event.Retry = ms
+			}
+		}
+	}
+	if err := scanner.Err(); errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("event stream line is longer than %d bytes: %w", maxInlineBody, err)
+	}
+	return scanner.Err()
+}
+
+// scanSSELines splits on CRLF, LF or a lone CR as the event stream format allows
+func scanSSELines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		// Wait for the next byte to tell a lone CR from CRLF
+		if !atEOF {
+			return 0, nil, nil
+		}
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// emitSSEStatus reports a stream state change to the frontend
+func (h *HTTPService) emitSSEStatus(id string, state string, err error) {
+	status := SSEStatus{StreamID: id, State: state}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	h.emitSSE(SSEStatusName, status)
+}
+
+// emitSSE sends an event through the event bus, if one is attached
+func (h *HTTPService) emitSSE(name string, data any) {
+	if h.events != nil {
+		h.events.EmitEvent(name, data)
+	}
+}
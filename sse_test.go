@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSSELineTooLongClosesStream(t *testing.T) {
+	var connections atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connections.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("retry: 10\n\ndata: " + strings.Repeat("x", maxInlineBody+1) + "\n\n"))
+	}))
+	t.Cleanup(srv.Close)
+
+	events := make(chan customEvent, 16)
+	h := newTestHTTPService(t)
+	h.events = NewEventBusService(events)
+
+	id, err := h.StreamSSE(context.Background(), HTTPRequest{Method: http.MethodGet, URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			status, ok := event.Data.(SSEStatus)
+			if !ok || status.State == SSEStateOpen {
+				continue
+			}
+			if status.State != SSEStateClosed || !strings.Contains(status.Error, "longer than") {
+				t.Fatalf("status = %+v, want the stream closed with a line length error", status)
+			}
+			// Give a wrongly scheduled reconnect the time to happen
+			time.Sleep(50 * time.Millisecond)
+			if n := connections.Load(); n != 1 {
+				t.Errorf("server saw %d connections, want 1", n)
+			}
+			if h.StopSSE(context.Background(), id) == nil {
+				t.Error("stream is still running after closing")
+			}
+			return
+		case <-timeout:
+			t.Fatal("stream was not closed")
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timing breaks down where the time of a send went, in milliseconds.
+// Phases that did not happen, such as DNS on a reused connection, are zero.
+type Timing struct {
+	DNSLookup        float64 `json:"dnsLookup"`
+	TCPConnect       float64 `json:"tcpConnect"`
+	TLSHandshake     float64 `json:"tlsHandshake"`
+	TimeToFirstByte  float64 `json:"timeToFirstByte"` // from the request being written to the first response byte
+	ContentTransfer  float64 `json:"contentTransfer"` // from the first response byte to the end of the body
+	Total            float64 `json:"total"`           // from sending the request to the end of the body
+	ConnectionReused bool    `json:"connectionReused"`
+}
+
+// requestTracer records connection events through httptrace. When a send is
+// redirected or retried, the timings describe the final request.
+type requestTracer struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	reused       bool
+}
+
+// newRequestTracer starts the clock for a send
+func newRequestTracer() *requestTracer {
+	return &requestTracer{start: time.Now()}
+}
+
+// clientTrace returns the hooks that feed the tracer
+func (t *requestTracer) clientTrace() *httptrace.ClientTrace {
+	mark := func(field *time.Time, onlyFirst bool) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if onlyFirst && !field.IsZero() {
+			return
+		}
+		*field = time.Now()
+	}
+
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			// A new hop starts; forget the phases of the previous one
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart, t.dnsDone = time.Time{}, time.Time{}
+			t.connectStart, t.connectDone = time.Time{}, time.Time{}
+			t.tlsStart, t.tlsDone = time.Time{}, time.Time{}
+			t.wroteRequest, t.firstByte = time.Time{}, time.Time{}
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { mark(&t.dnsStart, false) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { mark(&t.dnsDone, false) },
+		// Dialing may race several addresses; keep the first start and the last finish
+		ConnectStart:      func(string, string) { mark(&t.connectStart, true) },
+		ConnectDone:       func(string, string, error) { mark(&t.connectDone, false) },
+		TLSHandshakeStart: func() { mark(&t.tlsStart, false) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { mark(&t.tlsDone, false) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.reused = info.Reused
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { mark(&t.wroteRequest, false) },
+		GotFirstResponseByte: func() { mark(&t.firstByte, false) },
+	}
+}
+
+// timing returns the breakdown, treating end as the moment the body was fully read
+func (t *requestTracer) timing(end time.Time) *Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	between := func(from, to time.Time) float64 {
+		if from.IsZero() || to.IsZero() || to.Before(from) {
+			return 0
+		}
+		return float64(to.Sub(from).Microseconds()) / 1000
+	}
+
+	return &Timing{
+		DNSLookup:        between(t.dnsStart, t.dnsDone),
+		TCPConnect:       between(t.connectStart, t.connectDone),
+		TLSHandshake:     between(t.tlsStart, t.tlsDone),
+		TimeToFirstByte:  between(t.wroteRequest, t.firstByte),
+		ContentTransfer:  between(t.firstByte, end),
+		Total:            between(t.start, end),
+		ConnectionReused: t.reused,
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// certificateExpiryWarning is how close to expiry a certificate must be to produce a warning
+const certificateExpiryWarning = 30 * 24 * time.Hour
+
+// TLSInfo describes the TLS connection the final response arrived on
+type TLSInfo struct {
+	Version      string            `json:"version"`
+	CipherSuite  string            `json:"cipherSuite"`
+	ALPN         string            `json:"alpn,omitempty"`
+	ServerName   string            `json:"serverName"`
+	Verified     bool              `json:"verified"` // false when verification was skipped or failed
+	Certificates []CertificateInfo `json:"certificates"`
+	Warnings     []string          `json:"warnings,omitempty"`
+}
+
+// CertificateInfo summarizes one certificate of the peer chain, leaf first
+type CertificateInfo struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serialNumber"`
+	DNSNames           []string  `json:"dnsNames,omitempty"`
+	IPAddresses        []string  `json:"ipAddresses,omitempty"`
+	NotBefore          time.Time `json:"notBefore"`
+	NotAfter           time.Time `json:"notAfter"`
+	IsCA               bool      `json:"isCA"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	SHA1Fingerprint    string    `json:"sha1Fingerprint"`
+	SHA256Fingerprint  string    `json:"sha256Fingerprint"`
+}
+
+// newTLSInfo builds the TLS details for a connection to host. roots is the pool the
+// chain is checked against when verification was skipped; nil means the system pool.
+func newTLSInfo(state *tls.ConnectionState, host string, roots *x509.CertPool, now time.Time) *TLSInfo {
+	if state == nil {
+		return nil
+	}
+
+	info := &TLSInfo{
+		Version:      tls.VersionName(state.Version),
+		CipherSuite:  tls.CipherSuiteName(state.CipherSuite),
+		ALPN:         state.NegotiatedProtocol,
+		ServerName:   state.ServerName,
+		Verified:     len(state.VerifiedChains) > 0,
+		Certificates: make([]CertificateInfo, 0, len(state.PeerCertificates)),
+	}
+	if info.ServerName == "" {
+		info.ServerName = host
+	}
+
+	for _, cert := range state.PeerCertificates {
+		info.Certificates = append(info.Certificates, newCertificateInfo(cert))
+
+		name := cert.Subject.CommonName
+		if name == "" {
+			name = cert.Subject.String()
+		}
+		switch {
+		case now.After(cert.NotAfter):
+			info.Warnings = append(info.Warnings, fmt.Sprintf("certificate %q expired on %s",
+				name, cert.NotAfter.Format(time.RFC3339)))
+		case now.Before(cert.NotBefore):
+			info.Warnings = append(info.Warnings, fmt.Sprintf("certificate %q is not valid until %s",
+				name, cert.NotBefore.Format(time.RFC3339)))
+		case cert.NotAfter.Sub(now) < certificateExpiryWarning:
+			info.Warnings = append(info.Warnings, fmt.Sprintf("certificate %q expires in %d days",
+				name, int(cert.NotAfter.Sub(now).Hours()/24)))
+		}
+	}
+
+	if len(state.PeerCertificates) == 0 {
+		return info
+	}
+	leaf := state.PeerCertificates[0]
+
+	if err := leaf.VerifyHostname(host); err != nil {
+		info.Warnings = append(info.Warnings, fmt.Sprintf("hostname mismatch: %v", err))
+	}
+
+	// Verification was skipped, so say whether it would have passed
+	if !info.Verified {
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+		})
+		if err != nil {
+			info.Warnings = append(info.Warnings, fmt.Sprintf("certificate chain is not trusted: %v", err))
+		}
+	}
+
+	return info
+}
+
+// transportRoots returns the CA pool the client verifies u against, or nil for the system pool
+func transportRoots(client *http.Client, u *url.URL) *x509.CertPool {
+	t, ok := client.Transport.(*hostTransport)
+	if !ok {
+		return nil
+	}
+	transport, err := t.h.transportFor(t.settings, u)
+	if err != nil || transport.TLSClientConfig == nil {
+		return nil
+	}
+	return transport.TLSClientConfig.RootCAs
+}
+
+// newCertificateInfo summarizes a certificate
+func newCertificateInfo(cert *x509.Certificate) CertificateInfo {
+	sha1Sum := sha1.Sum(cert.Raw)
+	sha256Sum := sha256.Sum256(cert.Raw)
+
+	ips := make([]string, 0, len(cert.IPAddresses))
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+
+	return CertificateInfo{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       formatFingerprint(cert.SerialNumber.Bytes()),
+		DNSNames:           cert.DNSNames,
+		IPAddresses:        ips,
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		IsCA:               cert.IsCA,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		SHA1Fingerprint:    formatFingerprint(sha1Sum[:]),
+		SHA256Fingerprint:  formatFingerprint(sha256Sum[:]),
+	}
+}
+
+// formatFingerprint renders bytes as colon-separated uppercase hex
+func formatFingerprint(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02X", c)
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Variable represents a named value that can be substituted into a request using {{name}}
+type Variable struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+	// Secret values are encrypted at rest and masked in request logs
+	Secret bool `json:"secret,omitempty"`
+}
+
+// variablePattern matches {{name}} placeholders, allowing whitespace around the name
+var variablePattern = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+// variableResolver substitutes {{name}} placeholders and remembers any names it could not resolve.
+// Placeholders starting with $ are evaluated by the dynamic variable registry on every occurrence.
+type variableResolver struct {
+	values    map[string]string
+	missing   map[string]bool
+	errs      []error
+	generated []GeneratedVariable
+	// secrets holds the plaintext of secret variables so they can be masked when logging
+	secrets []string
+}
+
+// newVariableResolver creates a resolver for the given name/value pairs
+func newVariableResolver(values map[string]string) *variableResolver {
+	if values == nil {
+		values = make(map[string]string)
+	}
+	return &variableResolver{
+		values:  values,
+		missing: make(map[string]bool),
+	}
+}
+
+// expand replaces every placeholder in s with its value, leaving unresolved placeholders untouched
+func (r *variableResolver) expand(s string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+
+	return variablePattern.ReplaceAllStringFunc(s, func(match string) string {
+		name := variablePattern.FindStringSubmatch(match)[1]
+		if strings.HasPrefix(name, "$") {
+			return r.generate(name, match)
+		}
+		if value, ok := r.values[name]; ok {
+			return value
+		}
+		r.missing[name] = true
+		return match
+	})
+}
+
+// generate evaluates a dynamic placeholder such as "$randomInt 1 10" and records the produced value
+func (r *variableResolver) generate(expr string, match string) string {
+	fields := strings.Fields(strings.TrimPrefix(expr, "$"))
+	if len(fields) == 0 {
+		r.missing[expr] = true
+		return match
+	}
+
+	fn, ok := lookupDynamicVariable(fields[0])
+	if !ok {
+		r.missing[expr] = true
+		return match
+	}
+
+	value, err := fn(fields[1:], r.values)
+	if err != nil {
+		r.errs = append(r.errs, err)
+		return match
+	}
+
+	// A value derived from a secret variable, such as {{$base64 password}}, is secret too
+	for _, arg := range fields[1:] {
+		if v, ok := r.values[arg]; ok && v != "" && slices.Contains(r.secrets, v) {
+			r.secrets = append(r.secrets, value)
+			break
+		}
+	}
+
+	r.generated = append(r.generated, GeneratedVariable{Name: expr, Value: value})
+	return value
+}
+
+// err returns an error listing every unresolved variable and failed generator, or nil if all placeholders were resolved
+func (r *variableResolver) err() error {
+	if len(r.missing) == 0 && len(r.errs) == 0 {
+		return nil
+	}
+
+	var errs []error
+	if len(r.missing) > 0 {
+		names := make([]string, 0, len(r.missing))
+		for name := range r.missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		errs = append(errs, fmt.Errorf("unresolved variables: %s", strings.Join(names, ", ")))
+	}
+
+	return errors.Join(append(errs, r.errs...)...)
+}
+
+// Variable scopes, from lowest to highest precedence
+const (
+	VariableScopeGlobal      = "global"
+	VariableScopeCollection  = "collection"
+	VariableScopeEnvironment = "environment"
+	VariableScopeRequest     = "request"
+)
+
+// ResolvedVariable is the effective value of a variable together with the scope it came from
+type ResolvedVariable struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Scope  string `json:"scope"`
+	Secret bool   `json:"secret,omitempty"`
+}
+
+// ResolveVariables previews the variables that SendRequest would substitute into the request.
+// Precedence is request > environment > collection > global. Secret values are masked.
+func (h *HTTPService) ResolveVariables(ctx context.Context, req HTTPRequest) ([]ResolvedVariable, error) {
+	resolved, err := h.collectVariables(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ResolvedVariable, 0, len(resolved))
+	for _, v := range resolved {
+		if v.Secret {
+			v.Value = secretMask
+		}
+		result = append(result, v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+
+	return result, nil
+}
+
+// collectVariables merges every variable scope for a request, higher precedence scopes overriding lower ones.
+// Secret values are decrypted here, just before the request is sent.
+func (h *HTTPService) collectVariables(ctx context.Context, req HTTPRequest) (map[string]ResolvedVariable, error) {
+	resolved := make(map[string]ResolvedVariable)
+	var decryptErr error
+	apply := func(scope string, variables []Variable) {
+		for _, v := range variables {
+			if v.Key == "" {
+				continue
+			}
+			value := v.Value
+			if v.Secret && h.collectionService != nil {
+				plaintext, err := h.collectionService.secrets.decrypt(value)
+				if err != nil {
+					decryptErr = errors.Join(decryptErr, fmt.Errorf("variable %s: %w", v.Key, err))
+					continue
+				}
+				value = plaintext
+			}
+			resolved[v.Key] = ResolvedVariable{Key: v.Key, Value: value, Scope: scope, Secret: v.Secret}
+		}
+	}
+
+	if h.collectionService != nil {
+		if globals, err := h.collectionService.GetGlobalVariables(ctx); err == nil {
+			apply(VariableScopeGlobal, globals)
+		} else {
+			fmt.Printf("Warning: failed to load global variables: %v\n", err)
+		}
+
+		if req.CollectionID != "" {
+			if collection, err := h.collectionService.GetCollection(ctx, req.CollectionID); err == nil {
+				apply(VariableScopeCollection, collection.Variables)
+
+				for _, env := range collection.Environments {
+					if !env.IsActive {
+						continue
+					}
+					// The environment's base URL is exposed as {{baseUrl}} unless a variable overrides it
+					if env.BaseURL != "" {
+						apply(VariableScopeEnvironment, []Variable{{Key: "baseUrl", Value: env.BaseURL}})
+					}
+					apply(VariableScopeEnvironment, env.Variables)
+					break
+				}
+			}
+		}
+	}
+
+	apply(VariableScopeRequest, req.Variables)
+	if decryptErr != nil {
+		return nil, decryptErr
+	}
+	return resolved, nil
+}
+
+// resolvedVariablesToMap flattens resolved variables into a name/value lookup
+func resolvedVariablesToMap(resolved map[string]ResolvedVariable) map[string]string {
+	values := make(map[string]string, len(resolved))
+	for key, v := range resolved {
+		values[key] = v.Value
+	}
+	return values
+}
+
+// secretValues returns the plaintext of every secret variable in resolved
+func secretValues(resolved map[string]ResolvedVariable) []string {
+	var secrets []string
+	for _, v := range resolved {
+		if v.Secret && v.Value != "" {
+			secrets = append(secrets, v.Value)
+		}
+	}
+	return secrets
+}